@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/librescoot/smut/pkg/config"
+	"github.com/librescoot/smut/pkg/logging"
+	"github.com/librescoot/smut/pkg/redis"
+)
+
+// componentCheck is what the check command prints for each component
+type componentCheck struct {
+	Queued int           `json:"queued"` // entries in the update list
+	Update *queuedUpdate `json:"update"` // the entry the daemon would install, null if none
+}
+
+// queuedUpdate describes the update the daemon would take next
+type queuedUpdate struct {
+	URL        string `json:"url"`
+	Priority   int    `json:"priority"`
+	Checksum   string `json:"checksum,omitempty"`
+	UpdateType string `json:"update_type,omitempty"`
+	Version    string `json:"version,omitempty"`
+}
+
+// runCheck prints the update each configured component would install next as
+// JSON, keyed by component. The update list is only read, so the daemon still
+// takes the update, and no status is set.
+func runCheck(cfg *config.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+
+	redisClient, err := redis.NewClient(ctx, redisOptions(cfg))
+	if err != nil {
+		return err
+	}
+	defer redisClient.Close()
+
+	checks, err := checkUpdates(ctx, redisClient, cfg)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(checks, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding check result: %w", err)
+	}
+	fmt.Fprintln(os.Stdout, string(out))
+	return nil
+}
+
+// checkUpdates peeks at the update list of each configured component
+func checkUpdates(ctx context.Context, queue updateQueue, cfg *config.Config) (map[string]componentCheck, error) {
+	checks := make(map[string]componentCheck)
+	for _, component := range cfg.Components {
+		componentCfg := cfg.ForComponent(component)
+
+		entry, queued, err := queue.PeekUpdate(ctx, componentCfg.UpdateKey)
+		if err != nil {
+			return nil, err
+		}
+		check := componentCheck{Queued: queued}
+		if entry != nil {
+			check.Update = &queuedUpdate{
+				URL:        logging.RedactURL(entry.URL),
+				Priority:   entry.Priority,
+				Checksum:   entry.Checksum,
+				UpdateType: entry.UpdateType,
+				Version:    entry.Version,
+			}
+			if check.Update.Checksum == "" && componentCfg.ChecksumKey != "" {
+				check.Update.Checksum, err = queue.GetChecksum(ctx, componentCfg.ChecksumKey)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		checks[component] = check
+	}
+	return checks, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// Commands accepted on the control channel
+const (
+	commandCancel = "cancel"
+	commandPause  = "pause"
+	commandResume = "resume"
+)
+
+// updateControl lets commands from the control channel cancel the update
+// being handled, or hold it before its next phase until resumed
+type updateControl struct {
+	mu       sync.Mutex
+	cancel   context.CancelFunc // cancels the current update, nil when idle
+	canceled bool
+	paused   bool
+	resumed  chan struct{} // closed when a pause ends
+}
+
+func newUpdateControl() *updateControl {
+	return &updateControl{}
+}
+
+// begin derives the context for handling one update. The returned function
+// ends the update and reports whether it was canceled by a command.
+func (c *updateControl) begin(ctx context.Context) (context.Context, func() bool) {
+	updateCtx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
+	c.cancel = cancel
+	c.canceled = false
+	c.mu.Unlock()
+
+	return updateCtx, func() bool {
+		cancel()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.cancel = nil
+		return c.canceled
+	}
+}
+
+// dispatch applies a command received on the control channel
+func (c *updateControl) dispatch(command string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch command {
+	case commandCancel:
+		if c.cancel == nil {
+			logging.Infof("Received cancel command, but no update is in progress")
+			return
+		}
+		// An interrupted mender-update install can leave a half-written partition
+		if installs.inProgress() {
+			logging.Warnf("Received cancel command, ignoring it while an install is running")
+			return
+		}
+		logging.Infof("Received cancel command, canceling the current update")
+		c.canceled = true
+		c.cancel()
+	case commandPause:
+		if c.paused {
+			return
+		}
+		logging.Infof("Received pause command, holding updates before their next phase")
+		c.paused = true
+		c.resumed = make(chan struct{})
+	case commandResume:
+		if !c.paused {
+			return
+		}
+		logging.Infof("Received resume command, continuing updates")
+		c.paused = false
+		close(c.resumed)
+	default:
+		logging.Warnf("Ignoring unknown command '%s'", command)
+	}
+}
+
+// waitIfPaused blocks while updates are paused, returning early if ctx is
+// canceled. onHold is called once if the update has to wait.
+func (c *updateControl) waitIfPaused(ctx context.Context, onHold func()) error {
+	c.mu.Lock()
+	paused, resumed := c.paused, c.resumed
+	c.mu.Unlock()
+	if !paused {
+		return nil
+	}
+
+	logging.Infof("Updates paused, waiting for resume command")
+	onHold()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resumed:
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUpdateControlCancel(t *testing.T) {
+	c := newUpdateControl()
+	// Without an update in progress there is nothing to cancel
+	c.dispatch(commandCancel)
+
+	ctx, end := c.begin(context.Background())
+	c.dispatch(commandCancel)
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Errorf("update context = %v after cancel, want canceled", ctx.Err())
+	}
+	if !end() {
+		t.Error("end() did not report the cancel command")
+	}
+
+	_, end = c.begin(context.Background())
+	if end() {
+		t.Error("end() reported a cancel from an earlier update")
+	}
+}
+
+func TestUpdateControlPauseResume(t *testing.T) {
+	c := newUpdateControl()
+	held := 0
+	onHold := func() { held++ }
+	if err := c.waitIfPaused(context.Background(), onHold); err != nil || held != 0 {
+		t.Fatalf("waitIfPaused() without pause = %v, held %d times", err, held)
+	}
+
+	c.dispatch(commandPause)
+	c.dispatch(commandPause)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.waitIfPaused(context.Background(), onHold)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("waitIfPaused() returned %v while paused", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	c.dispatch(commandResume)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waitIfPaused() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitIfPaused() did not return after resume")
+	}
+	if held != 1 {
+		t.Errorf("onHold called %d times, want 1", held)
+	}
+
+	c.dispatch(commandPause)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.waitIfPaused(ctx, onHold); !errors.Is(err, context.Canceled) {
+		t.Errorf("waitIfPaused() with canceled context = %v", err)
+	}
+}
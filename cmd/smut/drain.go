@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// installGuard tracks installs in progress so a shutdown can let them finish
+// instead of interrupting mender-update halfway through writing a partition
+type installGuard struct {
+	mu       sync.Mutex
+	active   int
+	draining bool
+	idle     chan struct{} // closed whenever no install is in progress
+}
+
+func newInstallGuard() *installGuard {
+	idle := make(chan struct{})
+	close(idle)
+	return &installGuard{idle: idle}
+}
+
+// begin marks an install as started. It returns false once a shutdown is
+// draining, in which case the install must not be started.
+func (g *installGuard) begin() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining {
+		return false
+	}
+	if g.active == 0 {
+		g.idle = make(chan struct{})
+	}
+	g.active++
+	return true
+}
+
+// end marks an install started with begin as finished
+func (g *installGuard) end() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.active == 0 {
+		close(g.idle)
+	}
+}
+
+// drain stops new installs from starting and waits up to timeout for running
+// ones to finish. It reports whether all installs finished in time.
+func (g *installGuard) drain(timeout time.Duration) bool {
+	g.mu.Lock()
+	g.draining = true
+	active, idle := g.active, g.idle
+	g.mu.Unlock()
+
+	if active == 0 {
+		return true
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// inProgress reports whether an install is running
+func (g *installGuard) inProgress() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.active > 0
+}
+
+// isDraining reports whether a shutdown is waiting for installs to finish
+func (g *installGuard) isDraining() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.draining
+}
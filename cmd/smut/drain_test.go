@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInstallGuardDrain(t *testing.T) {
+	g := newInstallGuard()
+	if !g.drain(0) {
+		t.Fatal("drain() of an idle guard timed out")
+	}
+	if g.begin() {
+		t.Error("begin() started an install while draining")
+	}
+
+	g = newInstallGuard()
+	if !g.begin() {
+		t.Fatal("begin() refused an install")
+	}
+	if g.drain(10 * time.Millisecond) {
+		t.Error("drain() returned before the install finished")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		g.end()
+	}()
+	if !g.drain(5 * time.Second) {
+		t.Error("drain() timed out after the install finished")
+	}
+	if g.inProgress() || !g.isDraining() {
+		t.Errorf("inProgress = %v, isDraining = %v after drain", g.inProgress(), g.isDraining())
+	}
+}
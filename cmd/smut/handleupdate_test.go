@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librescoot/smut/pkg/config"
+	"github.com/librescoot/smut/pkg/download"
+	"github.com/librescoot/smut/pkg/mender"
+	"github.com/librescoot/smut/pkg/redis"
+	"github.com/librescoot/smut/pkg/watch"
+)
+
+// fakeRedis records the statuses handleUpdate reports
+type fakeRedis struct {
+	statuses        []string
+	lastFingerprint string
+	lastVersion     string
+	pending         bool
+	approvalErr     error
+}
+
+func (r *fakeRedis) SetStatus(ctx context.Context, status string) error {
+	r.statuses = append(r.statuses, status)
+	return nil
+}
+
+func (r *fakeRedis) SetDownloadAttempt(ctx context.Context, attempt, maxAttempts int) error {
+	return nil
+}
+func (r *fakeRedis) ClearDownloadAttempt(ctx context.Context) error                     { return nil }
+func (r *fakeRedis) SetDownloadProgress(ctx context.Context, percent int) error         { return nil }
+func (r *fakeRedis) SetInstallProgress(ctx context.Context, percent int) error          { return nil }
+func (r *fakeRedis) SetLastDownloadDuration(ctx context.Context, d time.Duration) error { return nil }
+func (r *fakeRedis) SetLastInstallDuration(ctx context.Context, d time.Duration) error  { return nil }
+func (r *fakeRedis) SetArtifactVersion(ctx context.Context, version string) error       { return nil }
+
+func (r *fakeRedis) GetLastFingerprint(ctx context.Context) (string, error) {
+	return r.lastFingerprint, nil
+}
+
+func (r *fakeRedis) SetLastUpdate(ctx context.Context, version, url, fingerprint string, ts time.Time) error {
+	r.lastVersion = version
+	r.lastFingerprint = fingerprint
+	return nil
+}
+
+func (r *fakeRedis) SetPendingArtifact(ctx context.Context, name string, deviceTypes, payloadTypes []string) error {
+	r.pending = true
+	return nil
+}
+
+func (r *fakeRedis) ClearPendingArtifact(ctx context.Context) error {
+	r.pending = false
+	return nil
+}
+
+func (r *fakeRedis) WaitForApproval(ctx context.Context, key string, ids ...string) error {
+	return r.approvalErr
+}
+
+func (r *fakeRedis) WaitWhilePaused(ctx context.Context, key string, onHold func()) error {
+	return nil
+}
+
+// fakeArtifacts downloads and verifies by writing a file to dir
+type fakeArtifacts struct {
+	dir         string
+	downloadErr error
+	digest      string
+	downloads   int
+	kept        []string
+}
+
+func (a *fakeArtifacts) SetArtifactID(id string)                      {}
+func (a *fakeArtifacts) SetRetryCallback(fn download.RetryFunc)       {}
+func (a *fakeArtifacts) SetProgressCallback(fn download.ProgressFunc) {}
+
+func (a *fakeArtifacts) Download(ctx context.Context, url string) (string, error) {
+	return strings.TrimPrefix(url, "file://"), a.downloadErr
+}
+
+func (a *fakeArtifacts) DownloadFromMirrors(ctx context.Context, urls []string) (string, error) {
+	a.downloads++
+	if a.downloadErr != nil {
+		return "", a.downloadErr
+	}
+	path := filepath.Join(a.dir, filepath.Base(urls[0]))
+	return path, os.WriteFile(path, []byte("artifact"), 0644)
+}
+
+func (a *fakeArtifacts) DownloadFromMirrorsWithChecksum(ctx context.Context, urls []string, algorithm string) (string, string, error) {
+	path, err := a.DownloadFromMirrors(ctx, urls)
+	return path, a.digest, err
+}
+
+func (a *fakeArtifacts) KeepArtifact(path string) error {
+	a.kept = append(a.kept, path)
+	return os.Remove(path)
+}
+
+func (a *fakeArtifacts) RemoteSidecarChecksumFromMirrors(ctx context.Context, urls []string, suffix string) (string, error) {
+	return "", nil
+}
+func (a *fakeArtifacts) LocalSidecarChecksum(filePath, suffix string) (string, error) {
+	return "", nil
+}
+func (a *fakeArtifacts) VerifyChecksum(filePath, checksumStr string) error {
+	return download.CompareDigest(a.digest, checksumStr)
+}
+func (a *fakeArtifacts) CacheArtifact(path, checksum string) error { return nil }
+
+// fakeInstaller stands in for mender-update
+type fakeInstaller struct {
+	name       string
+	current    string
+	installErr error
+	installed  []string
+	rollbacks  int
+}
+
+func (i *fakeInstaller) ReadArtifactInfo(filePath string) (*mender.ArtifactInfo, error) {
+	return &mender.ArtifactInfo{Name: i.name, DeviceTypes: []string{"librescoot-mdb"}}, nil
+}
+
+func (i *fakeInstaller) CurrentArtifactName(ctx context.Context) (string, error) {
+	return i.current, nil
+}
+func (i *fakeInstaller) SetProgressCallback(fn mender.ProgressFunc) {}
+
+func (i *fakeInstaller) Install(ctx context.Context, filePath string) error {
+	i.installed = append(i.installed, filePath)
+	return i.installErr
+}
+
+func (i *fakeInstaller) Rollback(ctx context.Context) error {
+	i.rollbacks++
+	return nil
+}
+
+func TestHandleUpdate(t *testing.T) {
+	const digest = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+	tests := []struct {
+		name    string
+		update  redis.UpdateRequest
+		setup   func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller)
+		wantErr error
+		// phase of the statusError returned, if any
+		phase     string
+		permanent bool
+		status    string
+		installs  int
+		rollbacks int
+		// whether the downloaded file is still there afterwards
+		fileKept bool
+	}{
+		{
+			name:     "installs the update",
+			update:   redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			status:   "installation-complete-waiting-reboot",
+			installs: 1,
+		},
+		{
+			name:   "download failure",
+			update: redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			setup: func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller) {
+				a.downloadErr = &download.DownloadError{Err: errors.New("404")}
+			},
+			phase:  "download",
+			status: "downloading-update-error",
+		},
+		{
+			name:      "checksum mismatch",
+			update:    redis.UpdateRequest{URL: "https://example.com/v2.mender", Checksum: "sha256:" + strings.Repeat("0", 64)},
+			phase:     "verify",
+			permanent: true,
+			status:    "downloading-update-error",
+		},
+		{
+			name:   "install failure",
+			update: redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			setup: func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller) {
+				i.installErr = &mender.InstallError{Err: errors.New("exit status 1")}
+			},
+			phase:    "install",
+			status:   "installing-update-error",
+			installs: 1,
+		},
+		{
+			name:   "artifact name rejected",
+			update: redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			setup: func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller) {
+				cfg.AllowedArtifactName = "librescoot-*"
+			},
+			phase:     "verify",
+			permanent: true,
+			status:    "download-complete",
+		},
+		{
+			name:   "device type rejected",
+			update: redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			setup: func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller) {
+				cfg.ExpectedDeviceType = "librescoot-dbc"
+			},
+			phase:     "verify",
+			permanent: true,
+			status:    "download-complete",
+		},
+		{
+			name:   "health check passes",
+			update: redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			setup: func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller) {
+				cfg.HealthCheckCmd = `test -f "$SMUT_ARTIFACT_PATH"`
+			},
+			status:   "installation-complete-waiting-reboot",
+			installs: 1,
+		},
+		{
+			name:   "health check fails",
+			update: redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			setup: func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller) {
+				cfg.HealthCheckCmd = "exit 1"
+			},
+			phase:     "health-check",
+			permanent: true,
+			status:    "installing-update-error",
+			installs:  1,
+			rollbacks: 1,
+		},
+		{
+			name:   "dry run",
+			update: redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			setup: func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller) {
+				cfg.DryRun = true
+			},
+			status: "dry-run-complete",
+		},
+		{
+			name:   "signature rejected",
+			update: redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			setup: func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller) {
+				cfg.InstallRetries = 2
+				i.installErr = &mender.InstallError{Err: mender.ErrSignatureVerification, Stderr: "failed to verify signature"}
+			},
+			phase:     "install",
+			permanent: true,
+			status:    "installing-updates",
+			installs:  1,
+		},
+		{
+			name:   "install retried",
+			update: redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			setup: func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller) {
+				cfg.InstallRetries = 2
+				i.installErr = &mender.InstallError{Err: errors.New("exit status 1")}
+			},
+			phase:    "install",
+			status:   "installing-update-error",
+			installs: 3,
+		},
+		{
+			name:   "already installed",
+			update: redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			setup: func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller) {
+				r.lastFingerprint = updateFingerprint("https://example.com/v2.mender", "")
+			},
+			wantErr: errAlreadyInstalled,
+			status:  "already-installed",
+		},
+		{
+			name:   "already running",
+			update: redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			setup: func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller) {
+				i.current = "v2"
+			},
+			wantErr: errAlreadyInstalled,
+			status:  "already-current",
+		},
+		{
+			name:   "force reinstall",
+			update: redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			setup: func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller) {
+				cfg.ForceReinstall = true
+				i.current = "v2"
+			},
+			status:   "installation-complete-waiting-reboot",
+			installs: 1,
+		},
+		{
+			name:     "blocking descriptor",
+			update:   redis.UpdateRequest{URL: "https://example.com/v2.mender", UpdateType: "blocking"},
+			status:   "installation-complete-waiting-dashboard-reboot",
+			installs: 1,
+		},
+		{
+			name:     "verifies the inline digest",
+			update:   redis.UpdateRequest{URL: "https://example.com/v2.mender", Checksum: "sha256:" + digest},
+			status:   "installation-complete-waiting-reboot",
+			installs: 1,
+		},
+		{
+			name:      "descriptor names another version",
+			update:    redis.UpdateRequest{URL: "https://example.com/v2.mender", Version: "v3"},
+			phase:     "verify",
+			permanent: true,
+			status:    "download-complete",
+		},
+		{
+			name:   "approval withheld",
+			update: redis.UpdateRequest{URL: "https://example.com/v2.mender"},
+			setup: func(cfg *config.Config, r *fakeRedis, a *fakeArtifacts, i *fakeInstaller) {
+				cfg.RequireApproval = true
+				r.approvalErr = context.Canceled
+			},
+			wantErr:  context.Canceled,
+			status:   "awaiting-approval",
+			fileKept: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{UpdateType: "non-blocking", InstallRetryDelay: time.Millisecond}
+			r := &fakeRedis{}
+			a := &fakeArtifacts{dir: t.TempDir(), digest: digest}
+			i := &fakeInstaller{name: "v2", current: "v1"}
+			if tt.setup != nil {
+				tt.setup(cfg, r, a, i)
+			}
+
+			err := handleUpdate(context.Background(), tt.update, a, a, i, r, r, r, cfg, nil, nil, newUpdateControl())
+
+			switch {
+			case tt.phase != "":
+				var se *statusError
+				if !errors.As(err, &se) || se.phase != tt.phase {
+					t.Fatalf("handleUpdate() error = %v, want failure in phase %s", err, tt.phase)
+				}
+				if got := isPermanentFailure(err); got != tt.permanent {
+					t.Errorf("isPermanentFailure = %v, want %v", got, tt.permanent)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("handleUpdate() error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("handleUpdate() error = %v", err)
+			}
+
+			if n := len(r.statuses); n == 0 || r.statuses[n-1] != tt.status {
+				t.Errorf("statuses = %v, want last %s", r.statuses, tt.status)
+			}
+			if len(i.installed) != tt.installs {
+				t.Errorf("installed %d times, want %d", len(i.installed), tt.installs)
+			}
+			if r.pending {
+				t.Error("pending artifact left set after install returned")
+			}
+			if tt.status == "installation-complete-waiting-reboot" && r.lastVersion != "v2" {
+				t.Errorf("last update version = %q, want v2", r.lastVersion)
+			}
+			if i.rollbacks != tt.rollbacks {
+				t.Errorf("rolled back %d times, want %d", i.rollbacks, tt.rollbacks)
+			}
+
+			files, _ := os.ReadDir(a.dir)
+			if kept := len(files) > 0; kept != tt.fileKept {
+				t.Errorf("download kept = %v, want %v", kept, tt.fileKept)
+			}
+		})
+	}
+}
+
+func TestHandleUpdateLocalFileIsNotRemoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "v2.mender")
+	if err := os.WriteFile(path, []byte("artifact"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{}
+	a := &fakeArtifacts{dir: t.TempDir()}
+	i := &fakeInstaller{name: "v2", current: "v1", installErr: &mender.InstallError{Err: errors.New("exit status 1")}}
+	cfg := &config.Config{UpdateType: "non-blocking"}
+
+	err := handleUpdate(context.Background(), redis.UpdateRequest{URL: "file://" + path}, a, a, i, r, r, r, cfg, nil, nil, newUpdateControl())
+	if err == nil {
+		t.Fatal("handleUpdate() succeeded, want install failure")
+	}
+	if a.downloads != 0 {
+		t.Errorf("downloaded %d times, want local file used in place", a.downloads)
+	}
+	for _, status := range r.statuses {
+		if status == "downloading-updates" {
+			t.Errorf("reported downloading-updates for a local file")
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("local artifact removed: %v", err)
+	}
+}
+
+// flakyInstaller fails its first installs
+type flakyInstaller struct {
+	fakeInstaller
+	failures int
+}
+
+func (i *flakyInstaller) Install(ctx context.Context, filePath string) error {
+	i.installed = append(i.installed, filePath)
+	if len(i.installed) <= i.failures {
+		return &mender.InstallError{Err: errors.New("exit status 1")}
+	}
+	return nil
+}
+
+func TestInstallWithRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		wantErr  bool
+		installs int
+	}{
+		{name: "fails twice then succeeds", retries: 2, installs: 3},
+		{name: "runs out of retries", retries: 1, wantErr: true, installs: 2},
+		{name: "no retries", retries: 0, wantErr: true, installs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &flakyInstaller{failures: 2}
+			err := installWithRetry(context.Background(), i, "/data/ota/v2.mender", tt.retries, time.Millisecond, 0)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("installWithRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(i.installed) != tt.installs {
+				t.Errorf("installed %d times, want %d", len(i.installed), tt.installs)
+			}
+		})
+	}
+}
+
+// fakeQueue records the updates put back on the update list
+type fakeQueue struct {
+	requeued []redis.UpdateRequest
+	err      error
+}
+
+func (q *fakeQueue) RequeueUpdate(ctx context.Context, updateKey string, update redis.UpdateRequest) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.requeued = append(q.requeued, update)
+	return nil
+}
+
+func TestSettleHold(t *testing.T) {
+	shutdown, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		requeueErr   error
+		wantRequeued bool
+	}{
+		{name: "shutdown", ctx: shutdown, wantRequeued: true},
+		{name: "shutdown without requeue", ctx: shutdown, requeueErr: errors.New("connection refused")},
+		{name: "cancel command", ctx: context.Background()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "v2.mender")
+			if err := os.WriteFile(path, []byte("artifact"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			q := &fakeQueue{err: tt.requeueErr}
+			update := redis.UpdateRequest{URL: "https://example.com/v2.mender"}
+
+			settleHold(tt.ctx, &holdError{path: path, err: context.Canceled}, q, "updates", update)
+
+			if requeued := len(q.requeued) == 1; requeued != tt.wantRequeued {
+				t.Errorf("requeued = %v, want %v", requeued, tt.wantRequeued)
+			}
+			// The download is kept exactly when the update is taken again
+			if _, err := os.Stat(path); (err == nil) != tt.wantRequeued {
+				t.Errorf("download kept = %v, want %v", err == nil, tt.wantRequeued)
+			}
+		})
+	}
+}
+
+func TestHandleUpdateFromWatchDir(t *testing.T) {
+	cfg := &config.Config{UpdateType: "non-blocking", WatchMount: t.TempDir(), WatchInterval: 10 * time.Millisecond}
+	watcher, err := watch.NewWatcher(cfg.WatchMount, cfg.WatchInterval)
+	if err != nil {
+		t.Fatalf("NewWatcher() error = %v", err)
+	}
+	defer watcher.Close()
+	path := filepath.Join(cfg.WatchMount, "v2.mender")
+	if err := os.WriteFile(path, []byte("artifact"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	url, err := watcher.Wait(ctx)
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	r := &fakeRedis{}
+	a := &fakeArtifacts{dir: t.TempDir()}
+	i := &fakeInstaller{name: "v2", current: "v1"}
+	if err := handleUpdate(ctx, redis.UpdateRequest{URL: url}, a, a, i, r, r, r, cfg, nil, nil, newUpdateControl()); err != nil {
+		t.Fatalf("handleUpdate() error = %v", err)
+	}
+	if len(i.installed) != 1 || i.installed[0] != path {
+		t.Errorf("installed %v, want the artifact from the watched directory in place", i.installed)
+	}
+	if a.downloads != 0 {
+		t.Errorf("downloaded %d times, want the watched artifact used in place", a.downloads)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("watched artifact removed: %v", err)
+	}
+}
+
+// sleepyInstaller installs until its context ends
+type sleepyInstaller struct {
+	fakeInstaller
+}
+
+func (i *sleepyInstaller) Install(ctx context.Context, filePath string) error {
+	i.installed = append(i.installed, filePath)
+	<-ctx.Done()
+	return &mender.InstallError{Err: ctx.Err()}
+}
+
+func TestInstallWithRetryTimeout(t *testing.T) {
+	i := &sleepyInstaller{}
+	err := installWithRetry(context.Background(), i, "/data/ota/v2.mender", 1, time.Millisecond, 50*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "timed out after 50ms") {
+		t.Errorf("installWithRetry() error = %v, want a timeout", err)
+	}
+	if len(i.installed) != 2 {
+		t.Errorf("installed %d times, want a timed out install retried", len(i.installed))
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/librescoot/smut/pkg/config"
+	"github.com/librescoot/smut/pkg/flock"
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// errInstallLocked is returned when the install lock is held by another
+// process for longer than --install-lock-timeout
+var errInstallLocked = errors.New("install lock is held by another process")
+
+// acquireInstallLock takes an exclusive flock on --install-lock-file for the
+// install or commit named by phase, so other maintenance jobs can detect the
+// update and hold off, and smut waits for theirs. While held, the file names
+// the holder. The lock is released by the returned function, or by the kernel
+// if smut dies. Without a lock file it does nothing.
+func acquireInstallLock(ctx context.Context, cfg *config.Config, phase string) (func(), error) {
+	if cfg.InstallLockFile == "" {
+		return func() {}, nil
+	}
+	path := cfg.InstallLockFile
+	onWait := func() {
+		logging.Infof("Install lock %s is held by another process, waiting", path)
+	}
+	lock, err := flock.Acquire(ctx, path, cfg.InstallLockTimeout, onWait)
+	if err != nil {
+		if errors.Is(err, flock.ErrContended) {
+			return nil, fmt.Errorf("%w: %s (waited %v)", errInstallLocked, path, cfg.InstallLockTimeout)
+		}
+		return nil, fmt.Errorf("error acquiring install lock: %w", err)
+	}
+
+	// The contents are informational, the flock is what counts
+	file := lock.File()
+	if err := file.Truncate(0); err == nil {
+		fmt.Fprintf(file, "pid=%d\ncomponent=%s\nphase=%s\nsince=%s\n", os.Getpid(), cfg.Component, phase, time.Now().UTC().Format(time.RFC3339))
+	}
+	logging.Debugf("Acquired install lock %s for %s", path, phase)
+	return func() {
+		file.Truncate(0)
+		lock.Release()
+		logging.Debugf("Released install lock %s", path)
+	}, nil
+}
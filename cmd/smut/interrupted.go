@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/librescoot/smut/pkg/config"
+	"github.com/librescoot/smut/pkg/logging"
+	"github.com/librescoot/smut/pkg/mender"
+	"github.com/librescoot/smut/pkg/redis"
+)
+
+// hasInterruptedInstall reports whether any component's ota hash still names
+// a pending artifact. The record is cleared as soon as mender-update install
+// returns, so smut was stopped while it was running.
+func hasInterruptedInstall(ctx context.Context, redisClient *redis.Client, cfg *config.Config) bool {
+	client := redisClient.Clone()
+	for _, component := range cfg.Components {
+		client.SetHashKey(cfg.ForComponent(component).OTAHashKey)
+		name, err := client.GetPendingArtifactName(ctx)
+		if err != nil {
+			logging.Warnf("Could not check for an interrupted install: %v", err)
+			continue
+		}
+		if name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// recoverInterruptedInstall applies --on-interrupted-install to an install
+// of this component that was interrupted, once mender confirms it did not
+// finish. It reports whether the install was resumed, leaving the update
+// waiting for a reboot.
+func recoverInterruptedInstall(ctx context.Context, redisClient *redis.Client, menderClient *mender.Client, cfg *config.Config) bool {
+	name, err := redisClient.GetPendingArtifactName(ctx)
+	if err != nil {
+		logging.Warnf("Could not check for an interrupted install: %v", err)
+		return false
+	}
+	if name == "" {
+		return false
+	}
+	defer func() {
+		if err := redisClient.ClearPendingArtifact(ctx); err != nil {
+			logging.Errorf("Error clearing pending artifact in Redis: %v", err)
+		}
+	}()
+
+	// The install may have finished just before smut was stopped, and the
+	// system rebooted into it. Then it only needs the commit skipped at startup.
+	if current, err := menderClient.CurrentArtifactName(ctx); err == nil && current == name {
+		logging.Infof("Install of %s finished before smut was stopped, mender reports it installed", name)
+		if err := checkAndCommitUpdate(ctx, menderClient, cfg); err != nil {
+			logging.Errorf("Error checking/committing update: %v", err)
+		}
+		return false
+	}
+	logging.Warnf("Install of %s was interrupted, handling it with policy %s", name, cfg.InterruptedInstall)
+
+	resumed := false
+	var recoverErr error
+	switch cfg.InterruptedInstall {
+	case "resume":
+		if recoverErr = menderClient.Resume(ctx); recoverErr == nil {
+			logging.Infof("Interrupted install of %s resumed", name)
+			resumed = true
+		}
+	case "rollback":
+		if recoverErr = menderClient.Rollback(ctx); recoverErr == nil {
+			logging.Infof("Interrupted install of %s rolled back", name)
+		}
+	default:
+		logging.Infof("Leaving interrupted install of %s to mender", name)
+	}
+	if errors.Is(recoverErr, mender.ErrNoUpdateInProgress) {
+		// mender-update install never got as far as starting the update
+		logging.Infof("mender has no update of %s in progress, nothing to recover", name)
+		return false
+	}
+
+	if !resumed {
+		message := fmt.Sprintf("install of %s was interrupted", name)
+		if recoverErr != nil {
+			logging.Errorf("Error recovering interrupted install: %v", recoverErr)
+			message = fmt.Sprintf("%s, %s failed: %v", message, cfg.InterruptedInstall, recoverErr)
+		}
+		failure := redis.Failure{Phase: "install", Message: message, Timestamp: time.Now().UTC()}
+		if err := redisClient.SetFailure(ctx, cfg.FailureKey, failure); err != nil {
+			logging.Errorf("Error setting failure in Redis: %v", err)
+		}
+	}
+	return resumed
+}
@@ -2,19 +2,30 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/librescoot/smut/pkg/config"
 	"github.com/librescoot/smut/pkg/download"
+	"github.com/librescoot/smut/pkg/logging"
 	"github.com/librescoot/smut/pkg/mender"
+	"github.com/librescoot/smut/pkg/metrics"
 	"github.com/librescoot/smut/pkg/redis"
+	"github.com/librescoot/smut/pkg/schedule"
+	"github.com/librescoot/smut/pkg/tracing"
+	"github.com/librescoot/smut/pkg/watch"
 )
 
 var Version string
@@ -22,18 +33,41 @@ var Version string
 func main() {
 	cfg, err := config.Parse()
 	if err != nil {
-		log.Fatalf("Error parsing configuration: %v", err)
+		logging.Fatalf("Error parsing configuration: %v", err)
+	}
+
+	if err := logging.Setup(cfg.LogLevel); err != nil {
+		logging.Fatalf("Error setting up logging: %v", err)
+	}
+
+	switch cfg.Command {
+	case config.CommandStatus:
+		if err := runStatus(cfg); err != nil {
+			logging.Fatalf("Error reading status: %v", err)
+		}
+		return
+	case config.CommandCheck:
+		if err := runCheck(cfg); err != nil {
+			logging.Fatalf("Error checking for updates: %v", err)
+		}
+		return
+	case config.CommandVerify:
+		if err := runVerify(cfg.Args[0], cfg.Args[1]); err != nil {
+			logging.Fatalf("Verification failed: %v", err)
+		}
+		return
 	}
 
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 	// Version is set at build time using ldflags
 	if Version == "" {
 		Version = "dev"
 	}
-	log.Printf("Simple Mender Update Tool %s starting with config: %+v", Version, cfg)
+	logging.Infof("Simple Mender Update Tool %s starting with config: %+v", Version, cfg)
 
-	if err := checkMenderAvailable(); err != nil {
-		log.Fatalf("Error checking mender-update: %v", err)
+	if cfg.DryRun {
+		logging.Warnf("Dry run: updates are downloaded and verified but never installed")
+	} else if err := checkMenderAvailable(); err != nil {
+		logging.Fatalf("Error checking mender-update: %v", err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -43,111 +77,440 @@ func main() {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigChan
-		log.Printf("Received signal %v, shutting down...", sig)
+		logging.Infof("Received signal %v, shutting down...", sig)
+		if installs.inProgress() {
+			logging.Infof("Install in progress, waiting up to %v for it to finish", cfg.ShutdownTimeout)
+		}
+		if !installs.drain(cfg.ShutdownTimeout) {
+			logging.Errorf("Install did not finish within %v, forcing exit", cfg.ShutdownTimeout)
+			// Kill mender-update rather than leaving it running behind us
+			cancel()
+			installs.drain(killTimeout)
+			os.Exit(1)
+		}
 		cancel()
 	}()
 
-	redisClient, err := redis.NewClient(ctx, cfg.RedisAddr)
+	redisClient, err := redis.NewClient(ctx, redisOptions(cfg))
 	if err != nil {
-		log.Fatalf("Error creating Redis client: %v", err)
+		logging.Fatalf("Error creating Redis client: %v", err)
 	}
 	defer redisClient.Close()
 
+	if cfg.VersionRegex != "" {
+		versionPattern = regexp.MustCompile(cfg.VersionRegex)
+	}
+
+	redisClient.SetMaxEntryLength(cfg.MaxEntryLength)
+	redisClient.SetBLPopTimeout(cfg.BLPopTimeout)
+	if err := redisClient.SetPublishPayload(cfg.PublishPayload); err != nil {
+		logging.Fatalf("Error configuring Redis client: %v", err)
+	}
+
+	// Optionally expose metrics and a health check for scraping
+	var collector *metrics.Metrics
+	if cfg.MetricsAddr != "" {
+		collector = metrics.New()
+		redisClient.SetStatusHook(collector.SetStatus)
+		go func() {
+			if err := collector.Serve(ctx, cfg.MetricsAddr, redisClient.Ping); err != nil {
+				logging.Errorf("Error serving metrics: %v", err)
+			}
+		}()
+	}
+
+	// An interrupted install is handled by its component instead, committing
+	// could keep a half-applied update. With the default ignore policy it is
+	// left to mender and the commit runs as always.
+	if !cfg.DryRun && (cfg.InterruptedInstall == "ignore" || !hasInterruptedInstall(ctx, redisClient, cfg)) {
+		if err := checkAndCommitUpdate(ctx, mender.NewClient(), cfg); err != nil {
+			logging.Errorf("Error checking/committing update: %v", err)
+		}
+	}
+
+	// Each component runs its own update loop, sharing the Redis connection
+	var wg sync.WaitGroup
+	for _, component := range cfg.Components {
+		componentCfg := cfg.ForComponent(component)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runComponent(ctx, componentCfg, redisClient.Clone(), collector)
+		}()
+	}
+	wg.Wait()
+}
+
+// userAgent identifies the download requests of smut and the component in
+// server logs
+func userAgent(component string) string {
+	if component == "" {
+		return "smut/" + Version
+	}
+	return fmt.Sprintf("smut/%s (%s)", Version, component)
+}
+
+// redisOptions builds the Redis connection options from the configuration
+func redisOptions(cfg *config.Config) redis.Options {
+	return redis.Options{
+		Addr:            cfg.RedisAddr,
+		DB:              cfg.RedisDB,
+		Username:        cfg.RedisUsername,
+		Password:        cfg.RedisPassword,
+		TLS:             cfg.RedisTLS,
+		CACert:          cfg.RedisCACert,
+		TLSSkipVerify:   cfg.RedisTLSSkipVerify,
+		ExpectID:        cfg.RedisExpectID,
+		IdentityKey:     cfg.RedisIdentityKey,
+		ConnectAttempts: cfg.ConnectAttempts,
+		ConnectTimeout:  cfg.ConnectTimeout,
+	}
+}
+
+// runComponent takes and installs updates for a single component until ctx is canceled
+func runComponent(ctx context.Context, cfg *config.Config, redisClient *redis.Client, collector *metrics.Metrics) {
 	// Set the update key and component in the Redis client
 	redisClient.SetUpdateKey(cfg.UpdateKey)
 	redisClient.SetComponent(cfg.Component)
+	redisClient.SetHashKey(cfg.OTAHashKey)
+	redisClient.SetStatusHistory(cfg.StatusHistoryKey, cfg.StatusHistoryLen)
 
 	// Set initial status and update type
 	if err := redisClient.SetStatus(ctx, "initializing"); err != nil {
-		log.Printf("Error setting initial status in Redis: %v", err)
+		logging.Errorf("Error setting initial status in Redis: %v", err)
 	}
 	if err := redisClient.SetUpdateType(ctx, cfg.UpdateType); err != nil {
-		log.Printf("Error setting initial update type in Redis: %v", err)
+		logging.Errorf("Error setting initial update type in Redis: %v", err)
 	}
 
 	downloadManager := download.NewManager(cfg.DownloadDir)
+	if err := downloadManager.SetStagingDir(cfg.StagingDir); err != nil {
+		logging.Fatalf("Error setting up staging directory: %v", err)
+	}
+	if err := downloadManager.SetCacheMaxBytes(cfg.CacheMaxBytes); err != nil {
+		logging.Fatalf("Error setting up artifact cache: %v", err)
+	}
+	if err := downloadManager.SetKeepArtifacts(cfg.KeepArtifacts); err != nil {
+		logging.Fatalf("Error setting up kept artifacts: %v", err)
+	}
+	downloadManager.SetSyncInterval(cfg.SyncBytes, cfg.SyncInterval)
+	downloadManager.SetCacheURL(cfg.CacheURL)
+	downloadManager.SetCheckpoints(cfg.Checkpoints)
+	downloadManager.SetResume(!cfg.NoResume)
+	downloadManager.SetSpaceMargin(cfg.SpaceMargin)
+	downloadManager.SetMinFreeSpace(cfg.MinFree)
+	downloadManager.SetRateLimit(cfg.RateLimit)
+	downloadManager.SetStallTimeout(cfg.StallTimeout)
+	downloadManager.SetParallelism(cfg.Parallelism)
+	downloadManager.SetPreflight(cfg.Preflight)
+	downloadManager.SetProgressInterval(cfg.ProgressInterval)
+	downloadManager.SetUserAgent(userAgent(cfg.Component))
+	if cfg.StaleAge > 0 {
+		if n, err := downloadManager.CleanStale(cfg.StaleAge); err != nil {
+			logging.Warnf("Failed to clean stale downloads: %v", err)
+		} else if n > 0 {
+			logging.Infof("Removed %d stale download file(s)", n)
+		}
+	}
+	downloadManager.SetTLSOptions(cfg.InsecureSkipVerify, cfg.AllowExpiredCerts)
+	downloadManager.SetAuth(cfg.AuthBearer, cfg.AuthBasic)
+	downloadManager.SetS3Credentials(cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3Region)
+	if err := downloadManager.SetProxy(cfg.Proxy); err != nil {
+		logging.Fatalf("Error configuring download proxy: %v", err)
+	}
 
 	menderClient := mender.NewClient()
+	menderClient.SetLockFile(cfg.MenderLockFile, cfg.MenderLockTimeout)
+	menderClient.SetVerifyKey(cfg.ArtifactVerifyKey)
+
+	tracer := tracing.NewTracer(cfg.OtelEndpoint, "smut", Version, cfg.Component)
+	// Export the trace of the update interrupted by a shutdown before exiting
+	defer tracer.Flush(tracing.ExportTimeout)
+
+	// Optionally take updates from a watched directory instead of Redis
+	var watcher *watch.Watcher
+	if cfg.WatchMount != "" {
+		var err error
+		watcher, err = watch.NewWatcher(cfg.WatchMount, cfg.WatchInterval)
+		if err != nil {
+			logging.Fatalf("Error watching %s: %v", cfg.WatchMount, err)
+		}
+		defer watcher.Close()
+	}
 
-	if err := checkAndCommitUpdate(menderClient); err != nil {
-		log.Printf("Error checking/committing update: %v", err)
+	// An install interrupted by a crash or power loss is rolled back, resumed
+	// or left alone before taking new updates
+	if !cfg.DryRun && recoverInterruptedInstall(ctx, redisClient, menderClient, cfg) {
+		if err := redisClient.SetStatus(ctx, "installation-complete-waiting-reboot"); err != nil {
+			logging.Errorf("Error setting status to installation-complete-waiting-reboot in Redis: %v", err)
+		}
+		if err := redisClient.SetUpdateType(ctx, "none"); err != nil {
+			logging.Errorf("Error setting update type to none in Redis: %v", err)
+		}
+		if cfg.RebootAfterInstall && cfg.UpdateType == "non-blocking" {
+			if err := reboot(ctx, cfg.RebootDelay); err != nil {
+				logging.Errorf("Error rebooting: %v", err)
+			}
+		}
+		logging.Infof("Interrupted update installed. Waiting for reboot...")
+		waitForReboot(ctx, redisClient, cfg, cfg.UpdateType)
+		return
+	}
+
+	// Let remote commands cancel or pause updates
+	control := newUpdateControl()
+	if cfg.CommandChannel != "" {
+		commands := redisClient.SubscribeCommands(ctx, cfg.CommandChannel)
+		go func() {
+			for command := range commands {
+				control.dispatch(command)
+			}
+		}()
 	}
 
+	typeOverridden := false
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Context canceled, exiting...")
+			logging.Infof("Context canceled, exiting...")
 			// Set status to unknown on exit
 			if err := redisClient.SetStatus(context.Background(), "unknown"); err != nil {
-				log.Printf("Error setting final status in Redis: %v", err)
+				logging.Errorf("Error setting final status in Redis: %v", err)
 			}
 			if err := redisClient.SetUpdateType(context.Background(), "none"); err != nil {
-				log.Printf("Error setting final update type in Redis: %v", err)
+				logging.Errorf("Error setting final update type in Redis: %v", err)
 			}
 			return
 		default:
 			// Set status to checking-updates before waiting
 			if err := redisClient.SetStatus(ctx, "checking-updates"); err != nil {
-				log.Printf("Error setting status to checking-updates in Redis: %v", err)
+				logging.Errorf("Error setting status to checking-updates in Redis: %v", err)
+			}
+			// An update descriptor only overrides the update type for its own update
+			if typeOverridden {
+				if err := redisClient.SetUpdateType(ctx, cfg.UpdateType); err != nil {
+					logging.Errorf("Error setting update type in Redis: %v", err)
+				}
+				typeOverridden = false
+			}
+
+			// Leave updates queued while the fleet is paused
+			if cfg.PauseKey != "" {
+				onHold := func() {
+					if err := redisClient.SetStatus(ctx, "paused"); err != nil {
+						logging.Errorf("Error setting status to paused in Redis: %v", err)
+					}
+				}
+				if err := redisClient.WaitWhilePaused(ctx, cfg.PauseKey, onHold); err != nil {
+					// Only returns early when the context is canceled
+					continue
+				}
 			}
 
-			url, _, err := redisClient.WaitForUpdate(ctx, cfg.UpdateKey, cfg.ChecksumKey)
+			waitStart := time.Now()
+			var update redis.UpdateRequest
+			var err error
+			if watcher != nil {
+				update.URL, err = watcher.Wait(ctx)
+			} else {
+				update, err = redisClient.WaitForUpdate(ctx, cfg.UpdateKey, cfg.ChecksumKey)
+			}
 			if err != nil {
 				if err == context.Canceled {
-					log.Println("Context canceled, exiting...")
+					logging.Infof("Context canceled, exiting...")
 					// Set status to unknown on exit
 					if err := redisClient.SetStatus(context.Background(), "unknown"); err != nil {
-						log.Printf("Error setting final status in Redis: %v", err)
+						logging.Errorf("Error setting final status in Redis: %v", err)
 					}
 					if err := redisClient.SetUpdateType(context.Background(), "none"); err != nil {
-						log.Printf("Error setting final update type in Redis: %v", err)
+						logging.Errorf("Error setting final update type in Redis: %v", err)
 					}
 					return
 				}
-				log.Printf("Error waiting for update: %v", err)
-				// Set status to checking-update-error on error
-				if err := redisClient.SetStatus(ctx, "checking-update-error"); err != nil {
-					log.Printf("Error setting status to checking-update-error in Redis: %v", err)
+				logging.Errorf("Error waiting for update: %v", err)
+				// Set status to checking-update-error on error, or malformed-queue-entry for bad producers
+				status := "checking-update-error"
+				if errors.Is(err, redis.ErrMalformedEntry) {
+					status = "malformed-queue-entry"
+				}
+				if err := redisClient.SetStatus(ctx, status); err != nil {
+					logging.Errorf("Error setting status to %s in Redis: %v", status, err)
 				}
 				time.Sleep(5 * time.Second)
 				continue
 			}
 
-			log.Printf("Received update URL: %s", url)
+			url := update.URL
+			logging.Infof("Received update URL: %s", logging.RedactURL(url))
 
-			if err := handleUpdate(ctx, url, downloadManager, menderClient, redisClient, cfg); err != nil {
-				log.Printf("Error handling update: %v", err)
+			updateType := effectiveUpdateType(cfg, update)
+			if update.UpdateType != "" {
+				logging.Infof("Update descriptor sets update type %s", update.UpdateType)
+				if err := redisClient.SetUpdateType(ctx, updateType); err != nil {
+					logging.Errorf("Error setting update type in Redis: %v", err)
+				}
+				typeOverridden = true
+			}
+
+			// Trace this update attempt, starting from when we began waiting for it
+			span := tracer.StartTrace("update", waitStart)
+			span.SetAttribute("update.url", logging.RedactURL(url))
+			span.SetAttribute("update.type", updateType)
+			span.StartChildAt("detect", waitStart).End(nil)
+
+			// Keep a concurrent updater from handling an update at the same time
+			var lock *redis.Lock
+			if cfg.LockKey != "" {
+				lock, err = redisClient.AcquireLock(ctx, cfg.LockKey, cfg.LockTTL)
+				if errors.Is(err, redis.ErrLockHeld) {
+					span.End(err)
+					// The update was already taken, put it back for whoever gets the lock next
+					if watcher != nil {
+						watcher.Retry(update.URL)
+					} else if err := redisClient.RequeueUpdate(context.Background(), cfg.UpdateKey, update); err != nil {
+						logging.Errorf("Lock %s is held by another updater and update %s could not be requeued: %v", cfg.LockKey, logging.RedactURL(url), err)
+						continue
+					}
+					logging.Warnf("Lock %s is held by another updater, requeued update %s, retrying in %v", cfg.LockKey, logging.RedactURL(url), requeueDelay)
+					select {
+					case <-ctx.Done():
+					case <-time.After(requeueDelay):
+					}
+					continue
+				}
+				if err != nil {
+					logging.Warnf("Could not acquire lock, handling update without it: %v", err)
+				}
+			}
+
+			updateCtx, endUpdate := control.begin(ctx)
+			err = handleUpdate(updateCtx, update, downloadManager, downloadManager, menderClient, redisClient, redisClient, redisClient, cfg, span, collector, control)
+			canceled := endUpdate()
+			if lock != nil {
+				if err := lock.Release(context.Background()); err != nil {
+					logging.Errorf("Error releasing lock: %v", err)
+				}
+			}
+			var held *holdError
+			if errors.As(err, &held) {
+				settleHold(ctx, held, redisClient, cfg.UpdateKey, update)
+			}
+			if canceled && ctx.Err() == nil {
+				logging.Warnf("Update %s canceled by command", logging.RedactURL(url))
+				span.End(err)
+				if err := redisClient.SetStatus(ctx, "update-canceled"); err != nil {
+					logging.Errorf("Error setting status to update-canceled in Redis: %v", err)
+				}
+				continue
+			}
+			if errors.Is(err, errAlreadyInstalled) {
+				span.End(nil)
+				continue
+			}
+			span.End(err)
+			if err != nil {
+				logging.Errorf("Error handling update: %v", err)
 				// Set status to appropriate error state based on handleUpdate error
-				status := "unknown" // Default to unknown
-				if strings.Contains(err.Error(), "download") {
-					status = "downloading-update-error"
-				} else if strings.Contains(err.Error(), "install") {
-					status = "installing-update-error"
+				phase, status := classifyFailure(err)
+				if phase != "unknown" {
+					if err := redisClient.SetStatus(ctx, status); err != nil {
+						logging.Errorf("Error setting error status in Redis: %v", err)
+					}
 				}
-				if err := redisClient.SetStatus(ctx, status); err != nil {
-					log.Printf("Error setting error status in Redis: %v", err)
+
+				failure := redis.Failure{Phase: phase, Message: err.Error(), Timestamp: time.Now().UTC()}
+				var installErr *mender.InstallError
+				if errors.As(err, &installErr) {
+					failure.Stderr, failure.Stdout = installErr.Stderr, installErr.Stdout
+				}
+				if err := redisClient.SetFailure(ctx, cfg.FailureKey, failure); err != nil {
+					logging.Errorf("Error setting failure in Redis: %v", err)
 				}
 
-				if err := redisClient.SetFailure(ctx, cfg.FailureKey, err.Error()); err != nil {
-					log.Printf("Error setting failure in Redis: %v", err)
+				// Put the update back so it survives a restart, unless retrying cannot help
+				if cfg.RequeueOnFailure && cfg.WatchMount == "" && !isPermanentFailure(err) {
+					if err := redisClient.RequeueUpdate(context.Background(), cfg.UpdateKey, update); err != nil {
+						logging.Errorf("Error requeueing update: %v", err)
+					} else {
+						logging.Infof("Requeued update %s, retrying in %v", logging.RedactURL(url), requeueDelay)
+						select {
+						case <-ctx.Done():
+						case <-time.After(requeueDelay):
+						}
+					}
 				}
+			} else if cfg.DryRun {
+				// Nothing was installed, keep taking updates
+				continue
 			} else {
 				// Set status to installation-complete-waiting-reboot on success
 				if err := redisClient.SetStatus(ctx, "installation-complete-waiting-reboot"); err != nil {
-					log.Printf("Error setting status to installation-complete-waiting-reboot in Redis: %v", err)
+					logging.Errorf("Error setting status to installation-complete-waiting-reboot in Redis: %v", err)
 				}
 				// Set update type to none on success
 				if err := redisClient.SetUpdateType(ctx, "none"); err != nil {
-					log.Printf("Error setting update type to none in Redis: %v", err)
+					logging.Errorf("Error setting update type to none in Redis: %v", err)
 				}
-				
-				// Wait for reboot instead of continuing to check for updates
-				log.Println("Update installed successfully. Waiting for reboot...")
-				select {
-				case <-ctx.Done():
-					log.Println("Context canceled, exiting...")
-					return
+
+				if cfg.RebootAfterInstall && updateType == "non-blocking" {
+					if err := reboot(ctx, cfg.RebootDelay); err != nil {
+						logging.Errorf("Error rebooting: %v", err)
+					}
 				}
+
+				// Wait for reboot instead of continuing to check for updates
+				logging.Infof("Update installed successfully. Waiting for reboot...")
+				waitForReboot(ctx, redisClient, cfg, updateType)
+				logging.Infof("Context canceled, exiting...")
+				return
+			}
+		}
+	}
+}
+
+// reboot reboots the system after delay, unless ctx is canceled first
+func reboot(ctx context.Context, delay time.Duration) error {
+	logging.Warnf("REBOOTING in %v to activate the installed update", delay)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+	}
+
+	logging.Warnf("REBOOTING NOW")
+	output, err := exec.Command("systemctl", "reboot").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error running systemctl reboot: %w, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// waitForReboot blocks until ctx is done. If the reboot that activates the
+// installed update has not happened after --reboot-wait-timeout, the status
+// is escalated to reboot-overdue and published again each further timeout,
+// or, with --reboot-when-overdue, a non-blocking update reboots itself.
+func waitForReboot(ctx context.Context, reporter statusReporter, cfg *config.Config, updateType string) {
+	if cfg.RebootWaitTimeout <= 0 {
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(cfg.RebootWaitTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		logging.Warnf("No reboot within %v of installing the update", cfg.RebootWaitTimeout)
+		if err := reporter.SetStatus(ctx, "reboot-overdue"); err != nil {
+			logging.Errorf("Error setting status to reboot-overdue in Redis: %v", err)
+		}
+		if cfg.RebootWhenOverdue && updateType == "non-blocking" {
+			if err := reboot(ctx, 0); err != nil {
+				logging.Errorf("Error rebooting: %v", err)
 			}
 		}
 	}
@@ -161,20 +524,35 @@ func checkMenderAvailable() error {
 	return nil
 }
 
-func checkAndCommitUpdate(menderClient *mender.Client) error {
-	needsCommit, err := menderClient.NeedsCommit()
+func checkAndCommitUpdate(ctx context.Context, menderClient *mender.Client, cfg *config.Config) error {
+	needsCommit, err := menderClient.NeedsCommit(ctx)
 	if err != nil {
 		return fmt.Errorf("error checking if update needs commit: %w", err)
 	}
 
 	if needsCommit {
-		log.Println("Update needs to be committed, committing...")
-		if err := menderClient.Commit(); err != nil {
+		unlock, err := acquireInstallLock(ctx, cfg, "commit")
+		if err != nil {
 			return fmt.Errorf("error committing update: %w", err)
 		}
-		log.Println("Update committed successfully")
+		defer unlock()
+
+		logging.Infof("Update needs to be committed, committing...")
+		commitCtx, cancel := phaseContext(ctx, cfg.CommitTimeout)
+		defer cancel()
+		if err := menderClient.Commit(commitCtx); err != nil {
+			if errors.Is(err, mender.ErrNoUpdateInProgress) {
+				logging.Infof("No update needs to be committed")
+				return nil
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("error committing update: timed out after %v: %w", cfg.CommitTimeout, err)
+			}
+			return fmt.Errorf("error committing update: %w", err)
+		}
+		logging.Infof("Update committed successfully")
 	} else {
-		log.Println("No update needs to be committed")
+		logging.Infof("No update needs to be committed")
 	}
 
 	return nil
@@ -182,89 +560,656 @@ func checkAndCommitUpdate(menderClient *mender.Client) error {
 
 func handleUpdate(
 	ctx context.Context,
-	url string,
-	downloadManager *download.Manager,
-	menderClient *mender.Client,
-	redisClient *redis.Client,
+	update redis.UpdateRequest,
+	downloader downloader,
+	verifier verifier,
+	installer installer,
+	reporter statusReporter,
+	ledger updateLedger,
+	gate installGate,
 	cfg *config.Config,
+	span *tracing.Span,
+	collector *metrics.Metrics,
+	control *updateControl,
 ) error {
+	url := update.URL
 	var downloadPath string
 	var err error
-	
-	// Check if this is a file:// URL
-	if strings.HasPrefix(url, "file://") {
-		// For file:// URLs, extract the path and skip downloading
-		filePath := strings.TrimPrefix(url, "file://")
-		log.Printf("Using local file: %s", filePath)
-		downloadPath = filePath
+
+	// onHold reports an update held by a pause command
+	onHold := func() {
+		if err := reporter.SetStatus(ctx, "paused"); err != nil {
+			logging.Errorf("Error setting status to paused in Redis: %v", err)
+		}
+	}
+	if err := control.waitIfPaused(ctx, onHold); err != nil {
+		return err
+	}
+
+	// The checksum comes from the update descriptor or the Redis checksum key,
+	// watched artifacts have none
+	checksum := update.Checksum
+
+	// Skip a repeated push of the update that is already installed
+	fingerprint := updateFingerprint(url, checksum)
+	if !cfg.ForceReinstall {
+		last, err := ledger.GetLastFingerprint(ctx)
+		if err != nil {
+			logging.Warnf("Could not retrieve last installed update from Redis: %v", err)
+		} else if last == fingerprint {
+			logging.Infof("Update %s is already installed, skipping", logging.RedactURL(url))
+			if err := reporter.SetStatus(ctx, "already-installed"); err != nil {
+				logging.Errorf("Error setting status to already-installed in Redis: %v", err)
+			}
+			return errAlreadyInstalled
+		}
+
+		// A descriptor naming the running version needs no download at all
+		if update.Version != "" {
+			current, err := installer.CurrentArtifactName(ctx)
+			if err != nil {
+				logging.Warnf("Could not read the installed artifact name: %v", err)
+			} else if current == update.Version {
+				logging.Infof("Artifact %s is already installed, skipping", current)
+				if err := reporter.SetStatus(ctx, "already-current"); err != nil {
+					logging.Errorf("Error setting status to already-current in Redis: %v", err)
+				}
+				return errAlreadyInstalled
+			}
+		}
+	}
+
+	// Digest computed inline while downloading, if the checksum algorithm is known up front
+	var digest string
+
+	// The update URL may list several comma-separated mirrors of the same artifact
+	mirrors := download.SplitMirrors(url)
+	if len(mirrors) == 0 {
+		return &statusError{phase: "download", status: "downloading-update-error", err: fmt.Errorf("error downloading update: no URL given")}
+	}
+	if len(mirrors) > 1 {
+		logging.Infof("Update is available from %d mirrors", len(mirrors))
+	}
+
+	// Local files (file:// URLs) are used in place and must never be removed
+	isLocal := len(mirrors) == 1 && download.IsLocalURL(mirrors[0])
+
+	// Without a checksum in Redis, look for a sidecar published next to the artifact
+	if checksum == "" && !isLocal && cfg.ChecksumSuffix != "" {
+		checksum, err = verifier.RemoteSidecarChecksumFromMirrors(ctx, mirrors, cfg.ChecksumSuffix)
+		if err != nil {
+			logging.Warnf("Could not read checksum sidecar: %v", err)
+		}
+	}
+
+	if isLocal {
+		// For file:// URLs, the download manager only validates the path
+		downloadPath, err = downloader.Download(ctx, url)
+		if err != nil {
+			return &statusError{phase: "download", status: "downloading-update-error", transient: true, err: fmt.Errorf("error accessing local update file: %w", err)}
+		}
 	} else {
 		// Set status to downloading-updates for non-file URLs
-		if err := redisClient.SetStatus(ctx, "downloading-updates"); err != nil {
-			log.Printf("Error setting status to downloading-updates in Redis: %v", err)
+		if err := reporter.SetStatus(ctx, "downloading-updates"); err != nil {
+			logging.Errorf("Error setting status to downloading-updates in Redis: %v", err)
 		}
 
-		downloadPath, err = downloadManager.Download(ctx, url)
+		downloadSpan := span.StartChild("download")
+
+		// Report retry attempts to Redis, and clear them again once done
+		retried := false
+		retries := 0
+		downloader.SetRetryCallback(func(attempt, maxAttempts int) {
+			retried = true
+			retries++
+			if err := reporter.SetDownloadAttempt(ctx, attempt, maxAttempts); err != nil {
+				logging.Errorf("Error setting download attempt in Redis: %v", err)
+			}
+		})
+		// Surface the download percentage whenever it changes
+		lastPercent := -1
+		downloader.SetProgressCallback(func(downloaded, total int64) {
+			if total <= 0 {
+				return
+			}
+			percent := int(downloaded * 100 / total)
+			if percent == lastPercent {
+				return
+			}
+			lastPercent = percent
+			if err := reporter.SetDownloadProgress(ctx, percent); err != nil {
+				logging.Errorf("Error setting download progress in Redis: %v", err)
+			}
+		})
+
+		// Resume a partial download of the same artifact under a refreshed URL
+		downloader.SetArtifactID(checksum)
+		downloadStart := time.Now()
+		downloadCtx, cancelDownload := phaseContext(ctx, cfg.DownloadTimeout)
+		algorithm, _, parseErr := download.ParseChecksum(checksum)
+		if checksum != "" && parseErr == nil && download.SupportedAlgorithm(algorithm) {
+			downloadPath, digest, err = downloader.DownloadFromMirrorsWithChecksum(downloadCtx, mirrors, algorithm)
+		} else {
+			downloadPath, err = downloader.DownloadFromMirrors(downloadCtx, mirrors)
+		}
+		cancelDownload()
+		if err != nil && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %v: %w", cfg.DownloadTimeout, err)
+		}
+		downloadSpan.SetAttribute("download.retries", retries)
+		if err == nil {
+			var size int64
+			if info, statErr := os.Stat(downloadPath); statErr == nil {
+				size = info.Size()
+				downloadSpan.SetAttribute("download.bytes", size)
+			}
+			collector.DownloadSucceeded(size)
+			if err := reporter.SetLastDownloadDuration(ctx, time.Since(downloadStart)); err != nil {
+				logging.Errorf("Error setting download duration in Redis: %v", err)
+			}
+		} else {
+			collector.DownloadFailed()
+		}
+		downloadSpan.End(err)
+		if retried {
+			if err := reporter.ClearDownloadAttempt(ctx); err != nil {
+				logging.Errorf("Error clearing download attempt in Redis: %v", err)
+			}
+		}
 		if err != nil {
 			// Set status to downloading-update-error on download error
-			if err := redisClient.SetStatus(ctx, "downloading-update-error"); err != nil {
-				log.Printf("Error setting status to downloading-update-error in Redis: %v", err)
+			if err := reporter.SetStatus(ctx, "downloading-update-error"); err != nil {
+				logging.Errorf("Error setting status to downloading-update-error in Redis: %v", err)
 			}
-			return fmt.Errorf("error downloading update: %w", err)
+			return &statusError{phase: "download", status: "downloading-update-error", transient: true, err: fmt.Errorf("error downloading update: %w", err)}
 		}
-		log.Printf("Downloaded update to: %s", downloadPath)
+		logging.Infof("Downloaded update to: %s", downloadPath)
 	}
 
-	checksum, err := redisClient.GetChecksum(ctx, cfg.ChecksumKey)
-	if err != nil {
-		log.Printf("Warning: Could not retrieve checksum from Redis: %v", err)
+	// Mark the end of the download phase explicitly, small and local artifacts
+	// finish before any progress is reported
+	if err := reporter.SetDownloadProgress(ctx, 100); err != nil {
+		logging.Errorf("Error setting download progress in Redis: %v", err)
+	}
+	if err := reporter.SetStatus(ctx, "download-complete"); err != nil {
+		logging.Errorf("Error setting status to download-complete in Redis: %v", err)
+	}
+
+	// For local files, fall back to a checksum sidecar next to the artifact
+	if checksum == "" && isLocal && cfg.ChecksumSuffix != "" {
+		checksum, err = verifier.LocalSidecarChecksum(downloadPath, cfg.ChecksumSuffix)
+		if err != nil {
+			logging.Warnf("Could not read checksum sidecar: %v", err)
+		}
 	}
 
+	verifySpan := span.StartChild("verify")
 	if checksum != "" {
-		log.Printf("Verifying checksum: %s", checksum)
-		if err := downloadManager.VerifyChecksum(downloadPath, checksum); err != nil {
-			os.Remove(downloadPath)
+		logging.Infof("Verifying checksum: %s", checksum)
+		if digest != "" {
+			err = download.CompareDigest(digest, checksum)
+		} else {
+			err = verifier.VerifyChecksum(downloadPath, checksum)
+		}
+		if err != nil {
+			verifySpan.End(err)
+			if !isLocal {
+				os.Remove(downloadPath)
+			}
 			// Set status to downloading-update-error on checksum mismatch
-			if err := redisClient.SetStatus(ctx, "downloading-update-error"); err != nil {
-				log.Printf("Error setting status to downloading-update-error in Redis: %v", err)
+			if err := reporter.SetStatus(ctx, "downloading-update-error"); err != nil {
+				logging.Errorf("Error setting status to downloading-update-error in Redis: %v", err)
+			}
+			return &statusError{phase: "verify", status: "downloading-update-error", err: fmt.Errorf("checksum verification failed: %w", err)}
+		}
+		logging.Infof("Checksum verification successful")
+		if !isLocal {
+			if err := verifier.CacheArtifact(downloadPath, checksum); err != nil {
+				logging.Warnf("Failed to cache artifact: %v", err)
 			}
-			return fmt.Errorf("checksum verification failed: %w", err)
 		}
-		log.Println("Checksum verification successful")
 	} else {
-		log.Println("No checksum provided, skipping verification")
+		logging.Infof("No checksum provided, skipping verification")
+	}
+
+	if cfg.AllowedArtifactName != "" {
+		if err := checkArtifactName(installer, downloadPath, cfg.AllowedArtifactName); err != nil {
+			verifySpan.End(err)
+			if !isLocal {
+				os.Remove(downloadPath)
+			}
+			return &statusError{phase: "verify", status: "artifact-name-rejected", err: err}
+		}
+	}
+
+	if cfg.ExpectedDeviceType != "" {
+		if err := checkDeviceType(installer, downloadPath, cfg.ExpectedDeviceType); err != nil {
+			verifySpan.End(err)
+			if !isLocal {
+				os.Remove(downloadPath)
+			}
+			return &statusError{phase: "verify", status: "device-type-rejected", err: err}
+		}
 	}
 
-	log.Println("Installing update...")
+	// Read the version now, the downloaded file is gone after install
+	version := artifactVersion(installer, downloadPath)
+	if versionPattern != nil {
+		// Names that don't encode a version clear the field of an earlier update
+		fileVersion, _ := parseVersion(filepath.Base(downloadPath))
+		if err := reporter.SetArtifactVersion(ctx, fileVersion); err != nil {
+			logging.Errorf("Error setting artifact version in Redis: %v", err)
+		}
+	}
+	if update.Version != "" && version != update.Version {
+		err := fmt.Errorf("artifact '%s' is not the version '%s' named by the update descriptor", version, update.Version)
+		verifySpan.End(err)
+		if !isLocal {
+			os.Remove(downloadPath)
+		}
+		return &statusError{phase: "verify", status: "artifact-name-rejected", err: err}
+	}
+
+	verifySpan.SetAttribute("verify.checksum", checksum != "")
+	verifySpan.End(nil)
+
+	// Installing the artifact that is already running would only wear the flash
+	if !cfg.ForceReinstall {
+		current, err := installer.CurrentArtifactName(ctx)
+		if err != nil {
+			logging.Warnf("Could not read the installed artifact name: %v", err)
+		} else if current == version {
+			logging.Infof("Artifact %s is already installed, skipping", version)
+			if !isLocal {
+				os.Remove(downloadPath)
+			}
+			if err := reporter.SetStatus(ctx, "already-current"); err != nil {
+				logging.Errorf("Error setting status to already-current in Redis: %v", err)
+			}
+			return errAlreadyInstalled
+		}
+	}
+
+	if cfg.DryRun {
+		logging.Infof("Dry run: would install %s (%s)", downloadPath, version)
+		if !isLocal {
+			os.Remove(downloadPath)
+		}
+		if err := reporter.SetStatus(ctx, "dry-run-complete"); err != nil {
+			logging.Errorf("Error setting status to dry-run-complete in Redis: %v", err)
+		}
+		return nil
+	}
+
+	// hold ends a hold of the verified update before its install, leaving the
+	// download to the caller, see settleHold
+	hold := func(err error) error {
+		if isLocal {
+			return err
+		}
+		return &holdError{path: downloadPath, err: err}
+	}
+
+	// Hold the verified update until an operator or orchestrator approves it
+	if cfg.RequireApproval {
+		logging.Infof("Waiting for approval of %s on %s", version, cfg.ApprovalKey)
+		if err := reporter.SetStatus(ctx, "awaiting-approval"); err != nil {
+			logging.Errorf("Error setting status to awaiting-approval in Redis: %v", err)
+		}
+		approvalSpan := span.StartChild("approval")
+		err := gate.WaitForApproval(ctx, cfg.ApprovalKey, version, checksum)
+		approvalSpan.End(err)
+		if err != nil {
+			return hold(err)
+		}
+	}
+
+	// Hold the verified update until the maintenance window opens
+	if cfg.MaintenanceStart != "" {
+		window, err := schedule.ParseWindow(cfg.MaintenanceStart, cfg.MaintenanceEnd)
+		if err != nil {
+			return &statusError{phase: "install", status: "installing-update-error", transient: true, err: err}
+		}
+		if !window.Contains(time.Now()) {
+			logging.Infof("Outside maintenance window %s, holding update for %v", window, window.UntilOpen(time.Now()).Round(time.Minute))
+			if err := reporter.SetStatus(ctx, "installation-pending"); err != nil {
+				logging.Errorf("Error setting status to installation-pending in Redis: %v", err)
+			}
+			windowSpan := span.StartChild("maintenance-window")
+			err := window.Wait(ctx)
+			windowSpan.End(err)
+			if err != nil {
+				return hold(err)
+			}
+			logging.Infof("Maintenance window %s open, installing held update", window)
+		}
+	}
+
+	if err := control.waitIfPaused(ctx, onHold); err != nil {
+		return hold(err)
+	}
+	// A fleet-wide pause set while the update was downloading holds it too
+	if cfg.PauseKey != "" {
+		if err := gate.WaitWhilePaused(ctx, cfg.PauseKey, onHold); err != nil {
+			return hold(err)
+		}
+	}
+
+	// Hold off shutdown until the install and its health check are done
+	if !installs.begin() {
+		return hold(context.Canceled)
+	}
+	defer installs.end()
+
+	// Let other maintenance jobs see the install, rollback included
+	unlock, err := acquireInstallLock(ctx, cfg, "install")
+	if err != nil {
+		return &statusError{phase: "install", status: "installing-update-error", transient: true, err: fmt.Errorf("error installing update: %w", err)}
+	}
+	defer unlock()
+
+	// Record what is about to be installed until mender-update install
+	// returns. A record left behind means it never did, see
+	// recoverInterruptedInstall.
+	clearPending := func() {}
+	if info, err := installer.ReadArtifactInfo(downloadPath); err != nil {
+		logging.Warnf("Could not read artifact info: %v", err)
+	} else {
+		if err := ledger.SetPendingArtifact(ctx, info.Name, info.DeviceTypes, info.PayloadTypes); err != nil {
+			logging.Errorf("Error setting pending artifact in Redis: %v", err)
+		}
+		clearPending = func() {
+			if err := ledger.ClearPendingArtifact(context.Background()); err != nil {
+				logging.Errorf("Error clearing pending artifact in Redis: %v", err)
+			}
+		}
+	}
+
+	logging.Infof("Installing update...")
+	installSpan := span.StartChild("install")
 	// Set status to installing-updates
-	if err := redisClient.SetStatus(ctx, "installing-updates"); err != nil {
-		log.Printf("Error setting status to installing-updates in Redis: %v", err)
+	if err := reporter.SetStatus(ctx, "installing-updates"); err != nil {
+		logging.Errorf("Error setting status to installing-updates in Redis: %v", err)
 	}
 
-	if err := menderClient.Install(downloadPath); err != nil {
-		os.Remove(downloadPath)
+	// Surface the install percentage reported by mender-update
+	installer.SetProgressCallback(func(percent int) {
+		if err := reporter.SetInstallProgress(ctx, percent); err != nil {
+			logging.Errorf("Error setting install progress in Redis: %v", err)
+		}
+	})
+	installStart := time.Now()
+	err = installWithRetry(ctx, installer, downloadPath, cfg.InstallRetries, cfg.InstallRetryDelay, cfg.InstallTimeout)
+	installDuration := time.Since(installStart)
+	clearPending()
+	installer.SetProgressCallback(nil)
+	installSpan.End(err)
+	if err != nil {
+		collector.InstallFailed()
+		if !isLocal {
+			os.Remove(downloadPath)
+		}
+		if errors.Is(err, mender.ErrSignatureVerification) {
+			return &statusError{phase: "install", status: "signature-verification-error", err: err}
+		}
 		// Set status to installing-update-error on install error
-		if err := redisClient.SetStatus(ctx, "installing-update-error"); err != nil {
-			log.Printf("Error setting status to installing-update-error in Redis: %v", err)
+		if err := reporter.SetStatus(ctx, "installing-update-error"); err != nil {
+			logging.Errorf("Error setting status to installing-update-error in Redis: %v", err)
+		}
+		return &statusError{phase: "install", status: "installing-update-error", transient: true, err: fmt.Errorf("error installing update: %w", err)}
+	}
+	logging.Infof("Update installed successfully")
+	collector.InstallSucceeded()
+	if err := reporter.SetLastInstallDuration(ctx, installDuration); err != nil {
+		logging.Errorf("Error setting install duration in Redis: %v", err)
+	}
+
+	// Check the installed update before it is kept, rolling back if unhealthy
+	if cfg.HealthCheckCmd != "" {
+		if err := runHealthCheck(cfg.HealthCheckCmd, downloadPath); err != nil {
+			logging.Errorf("Health check failed: %v", err)
+			// Not canceled with ctx, an interrupted rollback would leave the
+			// unhealthy update to be committed on the next boot
+			if rbErr := installer.Rollback(context.Background()); rbErr != nil {
+				logging.Errorf("Error rolling back update: %v", rbErr)
+			}
+			if !isLocal {
+				os.Remove(downloadPath)
+			}
+			if err := reporter.SetStatus(ctx, "installing-update-error"); err != nil {
+				logging.Errorf("Error setting status to installing-update-error in Redis: %v", err)
+			}
+			return &statusError{phase: "health-check", status: "installing-update-error", err: fmt.Errorf("error installing update: health check failed: %w", err)}
 		}
-		return fmt.Errorf("error installing update: %w", err)
+		logging.Infof("Health check passed")
 	}
-	log.Println("Update installed successfully")
 
-	// Only remove the file if it was downloaded (not a file:// URL)
-	if !strings.HasPrefix(url, "file://") {
-		if err := os.Remove(downloadPath); err != nil {
-			log.Printf("Warning: Failed to remove downloaded file %s: %v", downloadPath, err)
+	// Only remove the file if it was downloaded (not a file:// URL), or keep
+	// it with --keep-artifacts
+	if !isLocal {
+		if err := downloader.KeepArtifact(downloadPath); err != nil {
+			logging.Warnf("Failed to remove downloaded file %s: %v", downloadPath, err)
 		}
 	}
 
 	// Set final success status based on update type
 	successStatus := "installation-complete-waiting-reboot" // Default for non-blocking
-	if cfg.UpdateType == "blocking" {
+	if effectiveUpdateType(cfg, update) == "blocking" {
 		successStatus = "installation-complete-waiting-dashboard-reboot"
 	}
-	if err := redisClient.SetStatus(ctx, successStatus); err != nil {
-		log.Printf("Error setting final success status in Redis: %v", err)
+	if err := ledger.SetLastUpdate(ctx, version, logging.RedactURL(url), fingerprint, time.Now()); err != nil {
+		logging.Errorf("Error recording last update in Redis: %v", err)
+	}
+	if err := reporter.SetStatus(ctx, successStatus); err != nil {
+		logging.Errorf("Error setting final success status in Redis: %v", err)
 	}
 
 	return nil
 }
+
+// installs tracks running installs across all components for graceful shutdown
+var installs = newInstallGuard()
+
+// errAlreadyInstalled is returned by handleUpdate when the update matches the last installed one
+var errAlreadyInstalled = errors.New("update already installed")
+
+// effectiveUpdateType returns the update type of update, which its descriptor
+// may set instead of --update-type
+func effectiveUpdateType(cfg *config.Config, update redis.UpdateRequest) string {
+	if update.UpdateType != "" {
+		return update.UpdateType
+	}
+	return cfg.UpdateType
+}
+
+// updateFingerprint identifies an update request by its URL and checksum
+// without storing the URL's credentials or query in Redis
+func updateFingerprint(url, checksum string) string {
+	sum := sha256.Sum256([]byte(url + "\n" + checksum))
+	return hex.EncodeToString(sum[:])
+}
+
+// requeueDelay keeps a requeued update from being retried in a tight loop
+const requeueDelay = 30 * time.Second
+
+// isPermanentFailure reports whether handling the same update again cannot
+// succeed, because the artifact itself was rejected
+func isPermanentFailure(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && !se.transient
+}
+
+// statusError is an error that carries the phase it happened in and the
+// status to report in Redis for it. Unless transient, it rejects the update
+// for good.
+type statusError struct {
+	phase     string
+	status    string
+	transient bool
+	err       error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// holdError ends the hold of a verified update before its install, e.g.
+// on shutdown while waiting for approval. The download at path is kept.
+type holdError struct {
+	path string
+	err  error
+}
+
+func (e *holdError) Error() string {
+	return e.err.Error()
+}
+
+func (e *holdError) Unwrap() error {
+	return e.err
+}
+
+// settleHold disposes of the download of an update whose hold ended early. On
+// shutdown the update is put back at the head of the update list, so it is
+// taken again after the restart and the download manager reuses the download
+// if it can prove it current. Otherwise, e.g. after a cancel command, or if
+// the update cannot be put back, the download is removed.
+func settleHold(ctx context.Context, held *holdError, queue updateRequeuer, updateKey string, update redis.UpdateRequest) {
+	if ctx.Err() != nil {
+		err := queue.RequeueUpdate(context.Background(), updateKey, update)
+		if err == nil {
+			logging.Infof("Requeued held update %s, keeping its download for the restart", logging.RedactURL(update.URL))
+			return
+		}
+		logging.Errorf("Error requeueing held update %s: %v", logging.RedactURL(update.URL), err)
+	}
+	if err := os.Remove(held.path); err != nil && !os.IsNotExist(err) {
+		logging.Warnf("Failed to remove download of held update: %v", err)
+	}
+}
+
+// classifyFailure maps a handleUpdate error to the phase it failed in and
+// the status reported for it. Errors that are not classified, e.g. a context
+// canceled while an update was held, map to "unknown" and leave the status
+// as handleUpdate set it.
+func classifyFailure(err error) (phase, status string) {
+	var se *statusError
+	var checksumErr *download.ChecksumError
+	var downloadErr *download.DownloadError
+	var installErr *mender.InstallError
+	switch {
+	case errors.As(err, &se):
+		return se.phase, se.status
+	case errors.As(err, &checksumErr):
+		return "verify", "downloading-update-error"
+	case errors.As(err, &downloadErr):
+		return "download", "downloading-update-error"
+	case errors.As(err, &installErr):
+		return "install", "installing-update-error"
+	}
+	return "unknown", "unknown"
+}
+
+// killTimeout is how long a forced shutdown waits for a killed install to
+// return
+const killTimeout = 10 * time.Second
+
+// phaseContext derives the context of an update phase from ctx, with a
+// deadline after timeout unless it is 0
+func phaseContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// installWithRetry installs the artifact, retrying transient failures up to
+// retries times on the same file with an exponentially growing delay.
+// Signature failures are permanent and not retried. Each attempt is killed
+// after timeout, if set.
+func installWithRetry(ctx context.Context, installer installer, filePath string, retries int, delay, timeout time.Duration) error {
+	for attempt := 0; ; attempt++ {
+		installCtx, cancel := phaseContext(ctx, timeout)
+		err := installer.Install(installCtx, filePath)
+		cancel()
+		if err != nil && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %v: %w", timeout, err)
+		}
+		if err == nil || attempt >= retries || errors.Is(err, mender.ErrSignatureVerification) {
+			return err
+		}
+		if installs.isDraining() {
+			// A failed attempt is a safe point to stop at during shutdown
+			return err
+		}
+
+		wait := delay << uint(attempt)
+		logging.Warnf("Install attempt %d/%d failed: %v, retrying in %v", attempt+1, retries+1, err, wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runHealthCheck runs the health check command through the shell after an
+// install. The artifact path is passed in SMUT_ARTIFACT_PATH.
+func runHealthCheck(command, artifactPath string) error {
+	logging.Infof("Running health check: %s", command)
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), "SMUT_ARTIFACT_PATH="+artifactPath)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// checkArtifactName verifies that the artifact's name matches the allowed glob pattern
+func checkArtifactName(installer installer, filePath, pattern string) error {
+	info, err := installer.ReadArtifactInfo(filePath)
+	if err != nil {
+		return fmt.Errorf("error reading artifact info: %w", err)
+	}
+
+	matched, err := path.Match(pattern, info.Name)
+	if err != nil {
+		return fmt.Errorf("invalid allowed-artifact-name pattern: %w", err)
+	}
+	if !matched {
+		return fmt.Errorf("artifact name '%s' does not match allowed pattern '%s'", info.Name, pattern)
+	}
+
+	logging.Infof("Artifact name '%s' matches allowed pattern '%s'", info.Name, pattern)
+	return nil
+}
+
+// checkDeviceType verifies that the artifact was built for the expected device type
+func checkDeviceType(installer installer, filePath, deviceType string) error {
+	info, err := installer.ReadArtifactInfo(filePath)
+	if err != nil {
+		return fmt.Errorf("error reading artifact info: %w", err)
+	}
+
+	if !info.SupportsDevice(deviceType) {
+		return fmt.Errorf("artifact '%s' is built for device types %v, not '%s'", info.Name, info.DeviceTypes, deviceType)
+	}
+
+	logging.Infof("Artifact '%s' supports device type '%s'", info.Name, deviceType)
+	return nil
+}
+
+// artifactVersion returns the artifact name from the artifact header, falling
+// back to the filename without its extension
+func artifactVersion(installer installer, filePath string) string {
+	info, err := installer.ReadArtifactInfo(filePath)
+	if err == nil && info.Name != "" {
+		return info.Name
+	}
+	logging.Warnf("Could not read artifact name, using filename as version: %v", err)
+	return strings.TrimSuffix(filepath.Base(filePath), ".mender")
+}
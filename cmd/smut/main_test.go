@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/librescoot/smut/pkg/download"
+	"github.com/librescoot/smut/pkg/mender"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		phase     string
+		status    string
+		permanent bool
+	}{
+		{
+			name:      "rejected artifact",
+			err:       &statusError{phase: "verify", status: "artifact-name-rejected", err: errors.New("bad name")},
+			phase:     "verify",
+			status:    "artifact-name-rejected",
+			permanent: true,
+		},
+		{
+			name:   "download failure",
+			err:    &statusError{phase: "download", status: "downloading-update-error", transient: true, err: errors.New("timeout")},
+			phase:  "download",
+			status: "downloading-update-error",
+		},
+		{
+			name:   "mender lock contended during install",
+			err:    &statusError{phase: "install", status: "installing-update-error", transient: true, err: fmt.Errorf("error installing update: %w", mender.ErrLockContended)},
+			phase:  "install",
+			status: "installing-update-error",
+		},
+		{
+			name:   "install lock held",
+			err:    &statusError{phase: "install", status: "installing-update-error", transient: true, err: fmt.Errorf("error installing update: %w", errInstallLocked)},
+			phase:  "install",
+			status: "installing-update-error",
+		},
+		{
+			name:   "checksum mismatch",
+			err:    fmt.Errorf("wrapped: %w", &download.ChecksumError{Expected: "a", Actual: "b"}),
+			phase:  "verify",
+			status: "downloading-update-error",
+		},
+		{
+			name:   "download error",
+			err:    &download.DownloadError{Err: errors.New("404")},
+			phase:  "download",
+			status: "downloading-update-error",
+		},
+		{
+			name:   "install error",
+			err:    fmt.Errorf("error installing update: %w", &mender.InstallError{Err: errors.New("exit status 1")}),
+			phase:  "install",
+			status: "installing-update-error",
+		},
+		{
+			name:   "canceled while held",
+			err:    context.Canceled,
+			phase:  "unknown",
+			status: "unknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			phase, status := classifyFailure(tt.err)
+			if phase != tt.phase || status != tt.status {
+				t.Errorf("classifyFailure = %s/%s, want %s/%s", phase, status, tt.phase, tt.status)
+			}
+			if got := isPermanentFailure(tt.err); got != tt.permanent {
+				t.Errorf("isPermanentFailure = %v, want %v", got, tt.permanent)
+			}
+		})
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	if got, want := userAgent(""), "smut/"+Version; got != want {
+		t.Errorf("userAgent() = %q, want %q", got, want)
+	}
+	if got, want := userAgent("mdb"), "smut/"+Version+" (mdb)"; got != want {
+		t.Errorf("userAgent(mdb) = %q, want %q", got, want)
+	}
+}
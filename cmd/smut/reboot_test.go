@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librescoot/smut/pkg/config"
+)
+
+// fakeSystemctl puts a systemctl script running body first on PATH
+func fakeSystemctl(t *testing.T, body string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" + body + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "systemctl"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestReboot(t *testing.T) {
+	called := filepath.Join(t.TempDir(), "called")
+	fakeSystemctl(t, `echo "$@" > `+called)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := reboot(canceled, time.Hour); err == nil {
+		t.Error("reboot() with a canceled context succeeded")
+	}
+	if _, err := os.Stat(called); err == nil {
+		t.Fatal("systemctl ran although the reboot was canceled during the delay")
+	}
+
+	if err := reboot(context.Background(), time.Millisecond); err != nil {
+		t.Fatalf("reboot() error = %v", err)
+	}
+	args, _ := os.ReadFile(called)
+	if string(args) != "reboot\n" {
+		t.Errorf("systemctl called with %q, want reboot", args)
+	}
+
+	fakeSystemctl(t, `echo "Access denied" >&2; exit 1`)
+	if err := reboot(context.Background(), 0); err == nil || !strings.Contains(err.Error(), "Access denied") {
+		t.Errorf("reboot() with a failing systemctl = %v, want its output", err)
+	}
+}
+
+func TestWaitForRebootOverdue(t *testing.T) {
+	tests := []struct {
+		name       string
+		whenDue    bool
+		updateType string
+		wantReboot bool
+	}{
+		{name: "status only", updateType: "non-blocking"},
+		{name: "reboots when overdue", whenDue: true, updateType: "non-blocking", wantReboot: true},
+		{name: "leaves a blocking update to the dashboard", whenDue: true, updateType: "blocking"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := filepath.Join(t.TempDir(), "called")
+			fakeSystemctl(t, `echo "$@" > `+called)
+			r := &fakeRedis{}
+			cfg := &config.Config{RebootWaitTimeout: 20 * time.Millisecond, RebootWhenOverdue: tt.whenDue}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			waitForReboot(ctx, r, cfg, tt.updateType)
+
+			if len(r.statuses) == 0 || r.statuses[0] != "reboot-overdue" {
+				t.Errorf("statuses = %v, want reboot-overdue", r.statuses)
+			}
+			if _, err := os.Stat(called); (err == nil) != tt.wantReboot {
+				t.Errorf("rebooted = %v, want %v", err == nil, tt.wantReboot)
+			}
+		})
+	}
+}
+
+func TestWaitForRebootDisabled(t *testing.T) {
+	r := &fakeRedis{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	waitForReboot(ctx, r, &config.Config{}, "non-blocking")
+	if len(r.statuses) != 0 {
+		t.Errorf("statuses = %v, want none without reboot-wait-timeout", r.statuses)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/librescoot/smut/pkg/config"
+	"github.com/librescoot/smut/pkg/redis"
+)
+
+// statusTimeout bounds how long the status command waits for Redis
+const statusTimeout = 10 * time.Second
+
+// componentStatus is what the status command prints for each component
+type componentStatus struct {
+	OTA         map[string]string `json:"ota"`
+	LastFailure json.RawMessage   `json:"last_failure"`
+}
+
+// runStatus prints the ota hash and the last failure of each configured
+// component as JSON, keyed by component
+func runStatus(cfg *config.Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+
+	redisClient, err := redis.NewClient(ctx, redisOptions(cfg))
+	if err != nil {
+		return err
+	}
+	defer redisClient.Close()
+
+	statuses := make(map[string]componentStatus)
+	for _, component := range cfg.Components {
+		componentCfg := cfg.ForComponent(component)
+		redisClient.SetHashKey(componentCfg.OTAHashKey)
+
+		fields, err := redisClient.GetOTAFields(ctx)
+		if err != nil {
+			return err
+		}
+		failure, err := redisClient.GetFailure(ctx, componentCfg.FailureKey)
+		if err != nil {
+			return err
+		}
+		statuses[component] = componentStatus{OTA: fields, LastFailure: failureJSON(failure)}
+	}
+
+	out, err := json.MarshalIndent(statuses, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding status: %w", err)
+	}
+	fmt.Fprintln(os.Stdout, string(out))
+	return nil
+}
+
+// failureJSON returns the failure key's value as JSON. Values written before
+// failures were structured are plain strings and are quoted.
+func failureJSON(failure string) json.RawMessage {
+	if failure == "" {
+		return json.RawMessage("null")
+	}
+	if json.Valid([]byte(failure)) {
+		return json.RawMessage(failure)
+	}
+	quoted, _ := json.Marshal(failure)
+	return quoted
+}
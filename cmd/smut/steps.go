@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/librescoot/smut/pkg/download"
+	"github.com/librescoot/smut/pkg/mender"
+	"github.com/librescoot/smut/pkg/redis"
+)
+
+// downloader fetches update artifacts and disposes of them after install,
+// implemented by download.Manager
+type downloader interface {
+	SetArtifactID(id string)
+	SetRetryCallback(fn download.RetryFunc)
+	SetProgressCallback(fn download.ProgressFunc)
+	Download(ctx context.Context, url string) (string, error)
+	DownloadFromMirrors(ctx context.Context, urls []string) (string, error)
+	DownloadFromMirrorsWithChecksum(ctx context.Context, urls []string, algorithm string) (string, string, error)
+	KeepArtifact(path string) error
+}
+
+// verifier looks up and checks the checksum of a downloaded artifact,
+// implemented by download.Manager
+type verifier interface {
+	RemoteSidecarChecksumFromMirrors(ctx context.Context, urls []string, suffix string) (string, error)
+	LocalSidecarChecksum(filePath, suffix string) (string, error)
+	VerifyChecksum(filePath, checksumStr string) error
+	CacheArtifact(path, checksum string) error
+}
+
+// installer reads, installs and rolls back artifacts, implemented by
+// mender.Client
+type installer interface {
+	ReadArtifactInfo(filePath string) (*mender.ArtifactInfo, error)
+	CurrentArtifactName(ctx context.Context) (string, error)
+	SetProgressCallback(fn mender.ProgressFunc)
+	Install(ctx context.Context, filePath string) error
+	Rollback(ctx context.Context) error
+}
+
+// statusReporter publishes the progress of an update, implemented by
+// redis.Client
+type statusReporter interface {
+	SetStatus(ctx context.Context, status string) error
+	SetDownloadAttempt(ctx context.Context, attempt, maxAttempts int) error
+	ClearDownloadAttempt(ctx context.Context) error
+	SetDownloadProgress(ctx context.Context, percent int) error
+	SetInstallProgress(ctx context.Context, percent int) error
+	SetLastDownloadDuration(ctx context.Context, d time.Duration) error
+	SetLastInstallDuration(ctx context.Context, d time.Duration) error
+	SetArtifactVersion(ctx context.Context, version string) error
+}
+
+// updateLedger remembers the last installed and the in-flight artifact,
+// implemented by redis.Client
+type updateLedger interface {
+	GetLastFingerprint(ctx context.Context) (string, error)
+	SetLastUpdate(ctx context.Context, version, url, fingerprint string, ts time.Time) error
+	SetPendingArtifact(ctx context.Context, name string, deviceTypes, payloadTypes []string) error
+	ClearPendingArtifact(ctx context.Context) error
+}
+
+// installGate holds an install until it is approved and updates are not
+// paused, implemented by redis.Client
+type installGate interface {
+	WaitForApproval(ctx context.Context, key string, ids ...string) error
+	WaitWhilePaused(ctx context.Context, key string, onHold func()) error
+}
+
+// updateRequeuer puts an update back on the update list, implemented by
+// redis.Client
+type updateRequeuer interface {
+	RequeueUpdate(ctx context.Context, updateKey string, update redis.UpdateRequest) error
+}
+
+// updateQueue reads the update list without taking from it, implemented by
+// redis.Client
+type updateQueue interface {
+	PeekUpdate(ctx context.Context, updateKey string) (*redis.UpdateRequest, int, error)
+	GetChecksum(ctx context.Context, key string) (string, error)
+}
+
+var (
+	_ downloader = (*download.Manager)(nil)
+	_ verifier   = (*download.Manager)(nil)
+	_ installer  = (*mender.Client)(nil)
+
+	_ statusReporter = (*redis.Client)(nil)
+	_ updateLedger   = (*redis.Client)(nil)
+	_ installGate    = (*redis.Client)(nil)
+	_ updateRequeuer = (*redis.Client)(nil)
+	_ updateQueue    = (*redis.Client)(nil)
+)
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/librescoot/smut/pkg/download"
+)
+
+// runVerify checks the file at path against checksum, e.g. an artifact copied
+// by hand before it is pushed. The computed digest is printed in the same
+// algorithm:hash form either way, so a mismatch shows what the file really is.
+func runVerify(path, checksum string) error {
+	algorithm, _, err := download.ParseChecksum(checksum)
+	if err != nil {
+		return err
+	}
+	digest, err := download.FileDigest(path, algorithm)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "%s:%s  %s\n", algorithm, digest, path)
+	return download.CompareDigest(digest, checksum)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/librescoot/smut/pkg/download"
+)
+
+func TestRunVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "v2.mender")
+	if err := os.WriteFile(path, []byte("artifact"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("artifact"))
+	digest := hex.EncodeToString(sum[:])
+
+	if err := runVerify(path, "sha256:"+digest); err != nil {
+		t.Errorf("runVerify() with the right checksum = %v", err)
+	}
+	if err := runVerify(path, "sha256:"+strings.ToUpper(digest)); err != nil {
+		t.Errorf("runVerify() with an uppercase checksum = %v", err)
+	}
+	var mismatch *download.ChecksumError
+	if err := runVerify(path, "sha256:"+strings.Repeat("0", 64)); !errors.As(err, &mismatch) {
+		t.Errorf("runVerify() with a wrong checksum = %v, want a ChecksumError", err)
+	}
+	if err := runVerify(path, "md5:abc"); err == nil {
+		t.Error("runVerify() accepted an unsupported algorithm")
+	}
+	if err := runVerify(filepath.Join(t.TempDir(), "missing.mender"), "sha256:"+digest); err == nil {
+		t.Error("runVerify() of a missing file succeeded")
+	}
+}
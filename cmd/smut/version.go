@@ -0,0 +1,24 @@
+package main
+
+import "regexp"
+
+// versionPattern extracts the version from an artifact filename, set from
+// --version-regex at startup. nil disables version parsing.
+var versionPattern *regexp.Regexp
+
+// parseVersion returns the version encoded in filename, e.g. 2.4.1 in
+// librescoot-2.4.1-mdb.mender, as the first submatch of versionPattern or its
+// whole match if it has no group. ok is false if the name does not match.
+func parseVersion(filename string) (version string, ok bool) {
+	if versionPattern == nil {
+		return "", false
+	}
+	match := versionPattern.FindStringSubmatch(filename)
+	if match == nil {
+		return "", false
+	}
+	if len(match) > 1 {
+		return match[1], match[1] != ""
+	}
+	return match[0], match[0] != ""
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	defer func(pattern *regexp.Regexp) { versionPattern = pattern }(versionPattern)
+
+	tests := []struct {
+		pattern  string
+		filename string
+		version  string
+		ok       bool
+	}{
+		{pattern: `-(\d+\.\d+\.\d+)-`, filename: "librescoot-2.4.1-mdb.mender", version: "2.4.1", ok: true},
+		{pattern: `\d+\.\d+\.\d+`, filename: "librescoot-2.4.1-mdb.mender", version: "2.4.1", ok: true},
+		{pattern: `-(\d+\.\d+\.\d+)-`, filename: "nightly-mdb.mender"},
+		{pattern: `-(\d*)-`, filename: "librescoot--mdb.mender"},
+		{filename: "librescoot-2.4.1-mdb.mender"},
+	}
+	for _, tt := range tests {
+		versionPattern = nil
+		if tt.pattern != "" {
+			versionPattern = regexp.MustCompile(tt.pattern)
+		}
+		version, ok := parseVersion(tt.filename)
+		if version != tt.version || ok != tt.ok {
+			t.Errorf("parseVersion(%q) with %q = %q, %v, want %q, %v", tt.filename, tt.pattern, version, ok, tt.version, tt.ok)
+		}
+	}
+}
@@ -2,9 +2,15 @@ module github.com/librescoot/smut
 
 go 1.22.2
 
-require github.com/go-redis/redis/v8 v8.11.5
+require (
+	github.com/fsnotify/fsnotify v1.8.0
+	github.com/go-redis/redis/v8 v8.11.5
+	golang.org/x/crypto v0.31.0
+	gopkg.in/yaml.v2 v2.4.0
+)
 
 require (
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	golang.org/x/sys v0.28.0 // indirect
 )
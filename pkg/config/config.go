@@ -3,57 +3,446 @@ package config
 import (
 	"flag"
 	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/librescoot/smut/pkg/download"
+	"github.com/librescoot/smut/pkg/logging"
+	"github.com/librescoot/smut/pkg/schedule"
 )
 
 // Config holds the application configuration
 type Config struct {
+	Command    string   // Subcommand run instead of the update daemon, empty for the daemon
+	Args       []string // Arguments of the subcommand left after the flags
+	ConfigFile string   // YAML file with settings, overridden by command-line flags
+	LogLevel   string   // Minimum level that is logged: debug, info, warn or error
+
 	// Redis configuration
-	RedisAddr   string
-	UpdateKey   string
-	ChecksumKey string
-	FailureKey  string
-	UpdateType  string // New field for update type
-	Component   string // Component name (dbc, mdb)
+	RedisAddr          string
+	RedisDB            int    // Redis database number (0-15)
+	RedisUsername      string // ACL username, empty for the default user
+	RedisPassword      string // Password sent with AUTH, empty disables authentication
+	RedisTLS           bool   // Connect to Redis over TLS
+	RedisCACert        string // PEM CA bundle the Redis server certificate is verified against
+	RedisTLSSkipVerify bool   // Disable verification of the Redis server certificate
+	RedisExpectID      string // Expected value of the identity key, empty to skip the check
+	RedisIdentityKey   string // Key holding the Redis instance identity
+	KeyPrefix          string // Prepended to all Redis keys and channels except the identity key
+	UpdateKey          string
+	OTAHashKey         string // Hash status fields are written to and published on
+	PublishPayload     string // What is published on field changes: "field" or "json"
+	ChecksumKey        string
+	FailureKey         string
+	StatusHistoryKey   string        // Capped list every status is appended to
+	StatusHistoryLen   int           // Length the status history is trimmed to, 0 disables it
+	MaxEntryLength     int           // Maximum accepted length of an update list entry
+	BLPopTimeout       time.Duration // How long a single BLPOP blocks before polling again
+	ConnectAttempts    int           // How often the initial Redis ping is tried before giving up
+	ConnectTimeout     time.Duration // How long the initial Redis ping is retried, 0 only bounds the attempts
+	UpdateType         string        // New field for update type
+	Component          string        // Component name (dbc, mdb)
+	Components         []string      // Components handled by this process, parsed from a comma-separated Component
+	AllowNoComponent   bool          // Fall back to UnknownComponent instead of requiring Component
 
 	// Download configuration
-	DownloadDir string
+	DownloadDir        string
+	StagingDir         string        // Directory for partial downloads, empty uses DownloadDir
+	Proxy              string        // HTTP/HTTPS proxy for downloads, overrides HTTP_PROXY/HTTPS_PROXY
+	AuthBearer         string        // Bearer token sent with download requests
+	AuthBasic          string        // Basic auth credentials (user:password) sent with download requests
+	S3AccessKey        string        // AWS access key requests to S3 are signed with, empty disables signing
+	S3SecretKey        string        // AWS secret key requests to S3 are signed with
+	S3Region           string        // AWS region of the bucket, used for signing and s3:// URLs
+	InsecureSkipVerify bool          // Disable TLS certificate verification for downloads
+	AllowExpiredCerts  bool          // Ignore TLS certificate validity periods for downloads
+	RateLimit          int64         // Download bandwidth limit in bytes per second (0 = unlimited)
+	SpaceMargin        int64         // Bytes kept free in the download directory on top of the artifact
+	MinFree            int64         // Free bytes below which a running download is aborted, 0 disables
+	StallTimeout       time.Duration // Abort a download that receives no data for this long (0 disables)
+	Parallelism        int           // Concurrent Range requests per download, 1 uses a single stream
+	Preflight          bool          // Send a HEAD request before each download
+	ProgressInterval   time.Duration // How often download progress and speed are logged
+	StaleAge           time.Duration // Remove leftover downloads older than this at startup (0 disables)
+	CacheURL           string        // Caching proxy that downloads are routed through, empty disables
+	CacheMaxBytes      int64         // Byte budget of the local artifact cache, 0 disables
+	KeepArtifacts      int           // Number of installed artifacts kept for debugging, 0 removes them
+	ChecksumSuffix     string        // Suffix of checksum sidecar files (e.g. .sha256)
+	SyncBytes          int64         // Sync partial downloads to disk every N bytes (0 disables)
+	SyncInterval       time.Duration // Sync partial downloads to disk every interval (0 disables)
+	Checkpoints        bool          // Write chunk hash checkpoints at each sync to validate partials on resume
+	NoResume           bool          // Discard partial downloads left by earlier runs instead of resuming them
+
+	// Watch configuration
+	WatchMount    string        // Directory watched for artifacts instead of the Redis update list
+	WatchInterval time.Duration // Poll interval of the watched directory
+
+	// Telemetry configuration
+	OtelEndpoint string // OTLP/HTTP endpoint traces are exported to, empty disables tracing
+	MetricsAddr  string // Listen address of the /metrics and /healthz endpoints, empty disables
+
+	// Mender configuration
+	MaintenanceStart    string        // Local time (HH:MM) the maintenance window opens, empty installs any time
+	MaintenanceEnd      string        // Local time (HH:MM) the maintenance window closes
+	DryRun              bool          // Download and verify updates without installing them
+	ForceReinstall      bool          // Install updates even if they match the last installed one
+	AllowedArtifactName string        // Glob the artifact name must match before install, empty allows any
+	ExpectedDeviceType  string        // Device type the artifact must support before install, empty allows any
+	VersionRegex        string        // Pattern the version reported in Redis is parsed from the artifact filename with, empty disables
+	RebootAfterInstall  bool          // Reboot after a successful non-blocking install
+	RebootDelay         time.Duration // Delay between a successful install and the reboot
+	RebootWaitTimeout   time.Duration // How long to wait for a reboot after an install before escalating, 0 disables
+	RebootWhenOverdue   bool          // Reboot a non-blocking update when the reboot is overdue
+	InstallRetries      int           // How often a failed install is retried on the same artifact
+	InstallRetryDelay   time.Duration // Delay before the first install retry, doubled for each further retry
+	DownloadTimeout     time.Duration // Deadline for downloading an update, 0 disables
+	InstallTimeout      time.Duration // Deadline for each mender-update install attempt, 0 disables
+	CommitTimeout       time.Duration // Deadline for mender-update commit at startup, 0 disables
+	RequeueOnFailure    bool          // Push a failed update back onto the update list
+	ArtifactVerifyKey   string        // Public key PEM artifact signatures must verify against, empty disables
+	HealthCheckCmd      string        // Shell command run after install, the update is rolled back if it fails
+	ShutdownTimeout     time.Duration // How long a shutdown waits for a running install before forcing exit
+	MenderLockFile      string        // File flock'ed around mender operations, empty disables
+	MenderLockTimeout   time.Duration // How long to wait for the mender lock
+	InstallLockFile     string        // File flock'ed while smut installs or commits, for other maintenance jobs, empty disables
+	InstallLockTimeout  time.Duration // How long to wait for the install lock
+	InterruptedInstall  string        // What to do at startup about an install that did not finish: resume, rollback or ignore
+	LockKey             string        // Redis key leased while an update is handled, empty disables
+	LockTTL             time.Duration // TTL of the Redis lock, refreshed while held
+	CommandChannel      string        // Redis channel for cancel/pause/resume commands, empty disables
+	RequireApproval     bool          // Hold verified updates until approved through ApprovalKey
+	ApprovalKey         string        // Redis key and channel an update is approved on by its artifact name or checksum
+	PauseKey            string        // Redis key that holds all updates while set, empty disables
 }
 
 // Parse parses command-line arguments and returns a Config
 func Parse() (*Config, error) {
+	return parse(flag.CommandLine, os.Args[1:])
+}
+
+// parse defines the flags on fs and parses args, environment variables and
+// the config file into a Config
+func parse(fs *flag.FlagSet, args []string) (*Config, error) {
 	cfg := &Config{}
 
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "Minimum level that is logged: debug, info, warn or error")
+	fs.StringVar(&cfg.ConfigFile, "config", "", "YAML config file keyed by flag name (e.g. 'redis-addr: localhost:6379'); command-line flags take precedence")
+
 	// Redis configuration
-	flag.StringVar(&cfg.RedisAddr, "redis-addr", "localhost:6379", "Redis server address")
-	flag.StringVar(&cfg.UpdateKey, "update-key", "mender/update/url", "Redis key for update URLs")
-	flag.StringVar(&cfg.ChecksumKey, "checksum-key", "mender/update/checksum", "Redis key for checksums")
-	flag.StringVar(&cfg.FailureKey, "failure-key", "mender/update/last-failure", "Redis key to set on failure")
-	flag.StringVar(&cfg.UpdateType, "update-type", "non-blocking", "Type of update ('blocking' or 'non-blocking')") // New flag
+	fs.StringVar(&cfg.RedisAddr, "redis-addr", "localhost:6379", "Redis server address")
+	fs.IntVar(&cfg.RedisDB, "redis-db", 0, "Redis database number (0-15)")
+	fs.StringVar(&cfg.RedisUsername, "redis-username", "", "Redis ACL username (empty uses the default user)")
+	fs.StringVar(&cfg.RedisPassword, "redis-password", "", "Redis password sent with AUTH (empty disables authentication)")
+	fs.BoolVar(&cfg.RedisTLS, "redis-tls", false, "Connect to Redis over TLS")
+	fs.StringVar(&cfg.RedisCACert, "redis-ca-cert", "", "PEM CA bundle the Redis server certificate is verified against (empty uses the system roots)")
+	fs.BoolVar(&cfg.RedisTLSSkipVerify, "redis-tls-skip-verify", false, "INSECURE: disable verification of the Redis server certificate")
+	fs.StringVar(&cfg.RedisExpectID, "redis-expect-id", "", "Expected value of the Redis identity key; startup fails on mismatch (empty disables the check)")
+	fs.StringVar(&cfg.KeyPrefix, "key-prefix", "", "Prefix prepended to all Redis keys and channels, e.g. 'gen2/' (the identity key is not prefixed)")
+	fs.StringVar(&cfg.RedisIdentityKey, "redis-identity-key", "smut/redis-id", "Redis key holding the instance identity checked by --redis-expect-id")
+	fs.IntVar(&cfg.ConnectAttempts, "redis-connect-attempts", 10, "How often the initial connection to Redis is tried, with exponential backoff, before startup fails")
+	fs.DurationVar(&cfg.ConnectTimeout, "redis-connect-timeout", time.Minute, "How long the initial connection to Redis is retried before startup fails (0 only bounds the attempts)")
+	fs.StringVar(&cfg.UpdateKey, "update-key", "mender/update/url", "Redis key for update URLs")
+	fs.StringVar(&cfg.OTAHashKey, "ota-hash-key", "ota", "Redis hash status fields are written to and published on, e.g. ota:dbc to keep components apart")
+	fs.StringVar(&cfg.PublishPayload, "publish-payload", "field", "Message published on the OTA hash channel when a field changes: 'field' (the field name) or 'json' (field, value, component and timestamp)")
+	fs.StringVar(&cfg.ChecksumKey, "checksum-key", "mender/update/checksum", "Redis key for checksums")
+	fs.StringVar(&cfg.FailureKey, "failure-key", "mender/update/last-failure", "Redis key to set on failure")
+	fs.StringVar(&cfg.StatusHistoryKey, "status-history-key", "ota/status-history", "Redis list every status is pushed onto with its timestamp, newest first")
+	fs.IntVar(&cfg.StatusHistoryLen, "status-history-len", 0, "Number of entries kept in --status-history-key (0 disables the history)")
+	fs.DurationVar(&cfg.BLPopTimeout, "redis-blpop-timeout", 5*time.Second, "How long a single BLPOP on the update key blocks before checking for shutdown and polling again")
+	fs.IntVar(&cfg.MaxEntryLength, "max-entry-length", 4096, "Maximum accepted length in bytes of an update list entry")
+	fs.StringVar(&cfg.UpdateType, "update-type", "non-blocking", "Type of update ('blocking' or 'non-blocking')") // New flag
 
 	// Download configuration
-	flag.StringVar(&cfg.DownloadDir, "download-dir", "/tmp", "Directory to store downloaded update files")
+	fs.StringVar(&cfg.DownloadDir, "download-dir", "/tmp", "Directory to store downloaded update files")
+	fs.StringVar(&cfg.StagingDir, "staging-dir", "", "Directory to write partial downloads to, moved to --download-dir when complete (empty uses --download-dir)")
+	fs.Int64Var(&cfg.SyncBytes, "download-sync-bytes", 64*1024*1024, "Sync partial downloads to disk every N bytes (0 disables)")
+	fs.DurationVar(&cfg.SyncInterval, "download-sync-interval", 60*time.Second, "Sync partial downloads to disk at this interval (0 disables)")
+	fs.BoolVar(&cfg.NoResume, "no-resume", false, "Discard partial downloads left by earlier runs and download from scratch (retries within one download still resume)")
+	fs.BoolVar(&cfg.Checkpoints, "download-checkpoints", false, "Write chunk hash checkpoints at each sync and validate partial files against them before resuming")
+	fs.StringVar(&cfg.Proxy, "download-proxy", "", "HTTP/HTTPS proxy URL for downloads, overrides HTTP_PROXY/HTTPS_PROXY from the environment")
+	fs.StringVar(&cfg.AuthBearer, "download-auth-bearer", "", "Bearer token sent with download requests")
+	fs.StringVar(&cfg.AuthBasic, "download-auth-basic", "", "Basic auth credentials (user:password) sent with download requests")
+	fs.StringVar(&cfg.S3AccessKey, "s3-access-key", "", "AWS access key to sign requests to S3 with SigV4, for s3://bucket/key and S3 https URLs (empty disables signing)")
+	fs.StringVar(&cfg.S3SecretKey, "s3-secret-key", "", "AWS secret key to sign requests to S3 with")
+	fs.StringVar(&cfg.S3Region, "s3-region", "", "AWS region of the bucket, e.g. 'eu-central-1'; required for signing, and selects the endpoint of s3:// URLs")
+	fs.BoolVar(&cfg.InsecureSkipVerify, "insecure-skip-verify", false, "INSECURE: disable TLS certificate verification for downloads, allowing man-in-the-middle attacks; only rely on checksums/signatures when set")
+	fs.BoolVar(&cfg.AllowExpiredCerts, "allow-expired-certs", false, "Verify download TLS certificates but ignore their validity period, for devices without a reliable clock; expired or revoked certificates will be accepted")
+	fs.Int64Var(&cfg.RateLimit, "download-rate-limit", 0, "Download bandwidth limit in bytes per second (0 = unlimited)")
+	fs.Int64Var(&cfg.SpaceMargin, "download-space-margin", 16*1024*1024, "Bytes that must remain free in the download directory in addition to the artifact")
+	fs.Int64Var(&cfg.MinFree, "download-min-free", 4*1024*1024, "Abort a running download when free space for it drops below this many bytes, keeping the partial to resume (0 disables)")
+	fs.DurationVar(&cfg.StallTimeout, "download-stall-timeout", 0, "Abort a download when no data is received for this long, so the next retry or mirror can take over (0 disables)")
+	fs.IntVar(&cfg.Parallelism, "download-parallelism", 1, "Number of concurrent Range requests per download for servers that accept byte ranges (1 uses a single stream)")
+	fs.BoolVar(&cfg.Preflight, "download-preflight", true, "Send a HEAD request before each download to fail fast on a missing artifact and check free space")
+	fs.DurationVar(&cfg.ProgressInterval, "progress-interval", 5*time.Second, "How often download progress and speed are logged (at debug level)")
+	fs.DurationVar(&cfg.StaleAge, "download-stale-age", 7*24*time.Hour, "Remove artifacts and partial downloads in the download directory not modified for this long at startup (0 disables)")
+	fs.IntVar(&cfg.KeepArtifacts, "keep-artifacts", 0, "Keep the last N successfully installed artifacts in <download-dir>/kept for debugging instead of removing them (0 removes them)")
+	fs.Int64Var(&cfg.CacheMaxBytes, "cache-max-bytes", 0, "Keep up to this many bytes of verified artifacts in the download directory, reused by checksum instead of downloading again (0 disables)")
+	fs.StringVar(&cfg.CacheURL, "download-cache-url", "", "Caching proxy that downloads are routed through as <url>?target=<artifact url> (empty disables)")
+	fs.StringVar(&cfg.ChecksumSuffix, "checksum-suffix", ".sha256", "Suffix of checksum sidecar files, local or next to the artifact URL, used when no checksum is set in Redis")
+
+	// Watch configuration
+	fs.StringVar(&cfg.WatchMount, "watch-mount", "", "Directory (e.g. a USB stick mount point) watched for new .mender artifacts instead of the Redis update list")
+	var watchDir string
+	fs.StringVar(&watchDir, "watch-dir", "", "Alias for --watch-mount")
+	fs.DurationVar(&cfg.WatchInterval, "watch-interval", 2*time.Second, "How long a new artifact in --watch-mount must stay unchanged, and how often the directory is checked for a mount")
+
+	// Telemetry configuration
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", "", "Listen address (e.g. :9100) of the Prometheus /metrics and /healthz endpoints (empty disables)")
+	fs.StringVar(&cfg.OtelEndpoint, "otel-endpoint", "", "OTLP/HTTP endpoint (e.g. http://collector:4318) update traces are exported to (empty disables tracing)")
+
+	// Mender configuration
+	fs.StringVar(&cfg.MaintenanceStart, "maintenance-window-start", "", "Local time (HH:MM) from which updates may be installed; updates arriving outside the window are held until it opens (empty installs any time)")
+	fs.StringVar(&cfg.MaintenanceEnd, "maintenance-window-end", "", "Local time (HH:MM) after which updates are no longer installed")
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "Download and verify updates but never install them, setting the status to dry-run-complete instead")
+	fs.BoolVar(&cfg.ForceReinstall, "force-reinstall", false, "Install an update even if its URL and checksum match the last successfully installed update, or its artifact is the one already installed")
+	fs.StringVar(&cfg.AllowedArtifactName, "allowed-artifact-name", "", "Glob pattern the artifact name must match before install, e.g. 'librescoot-dbc-*' (empty allows any)")
+	fs.StringVar(&cfg.VersionRegex, "version-regex", `[0-9]+\.[0-9]+\.[0-9]+`, "Regular expression the version is parsed from the artifact filename with, taking its first group if it has one (empty disables)")
+	fs.StringVar(&cfg.ExpectedDeviceType, "expected-device-type", "", "Device type the artifact must list in its depends before install, e.g. 'librescoot-mdb' (empty allows any)")
+	fs.BoolVar(&cfg.RebootAfterInstall, "reboot-after-install", false, "Reboot with systemctl reboot after a successful non-blocking install instead of waiting for an external reboot")
+	fs.DurationVar(&cfg.RebootDelay, "reboot-delay", 10*time.Second, "Delay between a successful install and the reboot with --reboot-after-install")
+	fs.DurationVar(&cfg.RebootWaitTimeout, "reboot-wait-timeout", 0, "How long to wait for the reboot after a successful install before setting the status to reboot-overdue (0 waits forever)")
+	fs.BoolVar(&cfg.RebootWhenOverdue, "reboot-when-overdue", false, "Reboot with systemctl reboot when a non-blocking update's reboot is overdue")
+	fs.IntVar(&cfg.InstallRetries, "install-retries", 0, "How often a failed install is retried on the same downloaded artifact before giving up")
+	fs.DurationVar(&cfg.InstallRetryDelay, "install-retry-delay", 10*time.Second, "Delay before the first install retry, doubled for each further retry")
+	fs.DurationVar(&cfg.DownloadTimeout, "download-timeout", 0, "Deadline for downloading an update, including retries and mirrors (0 disables)")
+	fs.DurationVar(&cfg.InstallTimeout, "install-timeout", 0, "Deadline for each mender-update install attempt, after which it is killed (0 disables)")
+	fs.DurationVar(&cfg.CommitTimeout, "commit-timeout", 5*time.Minute, "Deadline for mender-update commit at startup, after which it is killed (0 disables)")
+	fs.BoolVar(&cfg.RequeueOnFailure, "requeue-on-failure", false, "Push the URL of a failed update back onto the head of the update list so it is retried, also after a restart; rejected artifacts are not requeued")
+	fs.StringVar(&cfg.ArtifactVerifyKey, "artifact-verify-key", "", "Public key PEM passed to mender-update install as --verify-key; unsigned or badly signed artifacts are refused (empty disables)")
+	fs.StringVar(&cfg.HealthCheckCmd, "health-check-cmd", "", "Shell command run after install and before reboot; on a non-zero exit the update is rolled back with mender-update rollback (empty disables)")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 5*time.Minute, "How long SIGINT/SIGTERM waits for a running install to finish before forcing exit")
+	fs.StringVar(&cfg.InterruptedInstall, "on-interrupted-install", "ignore", "What to do at startup about an install that was interrupted, e.g. by a crash: 'resume', 'rollback' or 'ignore'")
+	fs.StringVar(&cfg.MenderLockFile, "mender-lock-file", "", "Lock file flock'ed around mender install/commit to coordinate with other mender users, e.g. /run/mender.lock (empty disables)")
+	fs.DurationVar(&cfg.MenderLockTimeout, "mender-lock-timeout", 5*time.Minute, "How long to wait for the mender lock before failing")
+	fs.StringVar(&cfg.InstallLockFile, "install-lock-file", "", "Lock file flock'ed for the whole install, health check and commit, so other maintenance jobs can detect an update in progress (empty disables)")
+	fs.DurationVar(&cfg.InstallLockTimeout, "install-lock-timeout", 10*time.Minute, "How long to wait for --install-lock-file while another job holds it before failing")
+	fs.StringVar(&cfg.LockKey, "lock-key", "", "Redis key leased while an update is handled, so concurrent updaters skip instead of installing at the same time (empty disables)")
+	fs.DurationVar(&cfg.LockTTL, "lock-ttl", 60*time.Second, "TTL of the --lock-key lease, refreshed while an update is handled")
+	fs.StringVar(&cfg.CommandChannel, "command-channel", "", "Redis channel to receive cancel, pause and resume commands on, e.g. 'ota/commands' (empty disables)")
+	fs.BoolVar(&cfg.RequireApproval, "require-approval", false, "Hold verified updates with the status awaiting-approval until --approval-key is set to, or its channel receives, the artifact name or checksum")
+	fs.StringVar(&cfg.ApprovalKey, "approval-key", "ota/approve", "Redis key, also watched as a channel, that approves a held update with --require-approval")
+	fs.StringVar(&cfg.PauseKey, "pause-key", "", "Redis key, also watched as a channel, that holds updates with the status paused while set to anything but 0 or false (empty disables)")
 
 	// Add component flag
-	flag.StringVar(&cfg.Component, "component", "", "Component to update (e.g. dbc, mdb)")
+	fs.StringVar(&cfg.Component, "component", "", "Component to update (e.g. dbc, mdb), or a comma-separated list handled concurrently by one process")
+	fs.BoolVar(&cfg.AllowNoComponent, "allow-empty-component", false, "Run as component '"+UnknownComponent+"' if --component is not set, instead of failing")
+
+	// A leading subcommand runs a one-off action instead of the daemon
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cfg.Command = args[0]
+		args = args[1:]
+	}
+	switch cfg.Command {
+	case "", CommandStatus, CommandCheck, CommandVerify:
+	default:
+		return nil, fmt.Errorf("unknown command '%s'", cfg.Command)
+	}
 
 	// Parse flags
-	flag.Parse()
+	fs.Parse(args)
+	cfg.Args = fs.Args()
+	if cfg.Command == CommandVerify && len(cfg.Args) != 2 {
+		return nil, fmt.Errorf("verify takes a file and a checksum: smut verify [flags] <file> <checksum>")
+	}
+
+	// Fill in settings that were not given as flags from SMUT_* environment
+	// variables, then from the config file
+	if err := loadEnv(fs); err != nil {
+		return nil, err
+	}
+	if cfg.ConfigFile != "" {
+		if err := loadFile(fs, cfg.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if watchDir != "" {
+		if cfg.WatchMount != "" {
+			return nil, fmt.Errorf("watch-dir and watch-mount cannot be set together")
+		}
+		cfg.WatchMount = watchDir
+	}
+
+	cfg.applyKeyPrefix()
 
 	// Validate required parameters
 	if cfg.RedisAddr == "" {
 		return nil, fmt.Errorf("redis-addr is required")
 	}
+	if cfg.RedisDB < 0 || cfg.RedisDB > 15 {
+		return nil, fmt.Errorf("invalid redis-db %d, must be between 0 and 15", cfg.RedisDB)
+	}
+	if !cfg.RedisTLS && (cfg.RedisCACert != "" || cfg.RedisTLSSkipVerify) {
+		return nil, fmt.Errorf("redis-ca-cert and redis-tls-skip-verify require redis-tls")
+	}
 	if cfg.UpdateKey == "" {
 		return nil, fmt.Errorf("update-key is required")
 	}
+	if cfg.BLPopTimeout < time.Second {
+		return nil, fmt.Errorf("redis-blpop-timeout must be at least 1s")
+	}
+	if cfg.StatusHistoryLen < 0 {
+		return nil, fmt.Errorf("status-history-len must not be negative")
+	}
+	if cfg.ConnectAttempts < 1 {
+		return nil, fmt.Errorf("redis-connect-attempts must be at least 1")
+	}
+	if cfg.ConnectTimeout < 0 {
+		return nil, fmt.Errorf("redis-connect-timeout must not be negative")
+	}
+	if cfg.OTAHashKey == "" {
+		return nil, fmt.Errorf("ota-hash-key is required")
+	}
 	if cfg.FailureKey == "" {
 		return nil, fmt.Errorf("failure-key is required")
 	}
 	if cfg.DownloadDir == "" {
 		return nil, fmt.Errorf("download-dir is required")
 	}
-	if cfg.Component == "" {
-		return nil, fmt.Errorf("component is required")
+	if (cfg.S3AccessKey == "") != (cfg.S3SecretKey == "") {
+		return nil, fmt.Errorf("s3-access-key and s3-secret-key must be set together")
+	}
+	if cfg.S3AccessKey != "" && cfg.S3Region == "" {
+		return nil, fmt.Errorf("s3-access-key requires s3-region")
+	}
+	if cfg.AuthBasic != "" && !strings.Contains(cfg.AuthBasic, ":") {
+		return nil, fmt.Errorf("download-auth-basic must be in the form user:password")
+	}
+	if cfg.RateLimit < 0 {
+		return nil, fmt.Errorf("download-rate-limit must not be negative")
+	}
+	if cfg.SpaceMargin < 0 {
+		return nil, fmt.Errorf("download-space-margin must not be negative")
+	}
+	if cfg.KeepArtifacts < 0 {
+		return nil, fmt.Errorf("keep-artifacts must not be negative")
+	}
+	if cfg.CacheMaxBytes < 0 {
+		return nil, fmt.Errorf("cache-max-bytes must not be negative")
+	}
+	if cfg.MinFree < 0 {
+		return nil, fmt.Errorf("download-min-free must not be negative")
+	}
+	if cfg.StallTimeout < 0 {
+		return nil, fmt.Errorf("download-stall-timeout must not be negative")
+	}
+	if cfg.Parallelism < 1 {
+		return nil, fmt.Errorf("download-parallelism must be at least 1")
+	}
+	if cfg.ProgressInterval <= 0 {
+		return nil, fmt.Errorf("progress-interval must be positive")
+	}
+	if cfg.StaleAge < 0 {
+		return nil, fmt.Errorf("download-stale-age must not be negative")
+	}
+	if cfg.SyncBytes < 0 {
+		return nil, fmt.Errorf("download-sync-bytes must not be negative")
+	}
+	if cfg.WatchMount != "" && cfg.WatchInterval <= 0 {
+		return nil, fmt.Errorf("watch-interval must be positive")
+	}
+	if _, err := path.Match(cfg.AllowedArtifactName, ""); err != nil {
+		return nil, fmt.Errorf("invalid allowed-artifact-name '%s': %w", cfg.AllowedArtifactName, err)
+	}
+	if _, err := regexp.Compile(cfg.VersionRegex); err != nil {
+		return nil, fmt.Errorf("invalid version-regex '%s': %w", cfg.VersionRegex, err)
+	}
+	if cfg.ChecksumSuffix != "" {
+		if _, err := download.SidecarAlgorithm(cfg.ChecksumSuffix); err != nil {
+			return nil, fmt.Errorf("invalid checksum-suffix: %w", err)
+		}
+	}
+	if (cfg.MaintenanceStart == "") != (cfg.MaintenanceEnd == "") {
+		return nil, fmt.Errorf("maintenance-window-start and maintenance-window-end must be set together")
+	}
+	if cfg.MaintenanceStart != "" {
+		if _, err := schedule.ParseWindow(cfg.MaintenanceStart, cfg.MaintenanceEnd); err != nil {
+			return nil, fmt.Errorf("invalid maintenance window: %w", err)
+		}
+	}
+	if cfg.RebootDelay < 0 {
+		return nil, fmt.Errorf("reboot-delay must not be negative")
+	}
+	switch cfg.InterruptedInstall {
+	case "rollback", "resume", "ignore":
+	default:
+		return nil, fmt.Errorf("invalid on-interrupted-install '%s', must be 'rollback', 'resume' or 'ignore'", cfg.InterruptedInstall)
+	}
+	if cfg.RebootWaitTimeout < 0 {
+		return nil, fmt.Errorf("reboot-wait-timeout must not be negative")
+	}
+	if cfg.RebootWhenOverdue && cfg.RebootWaitTimeout == 0 {
+		return nil, fmt.Errorf("reboot-when-overdue requires reboot-wait-timeout")
+	}
+	if cfg.InstallRetries < 0 {
+		return nil, fmt.Errorf("install-retries must not be negative")
+	}
+	if cfg.InstallRetryDelay < 0 {
+		return nil, fmt.Errorf("install-retry-delay must not be negative")
+	}
+	if cfg.DownloadTimeout < 0 || cfg.InstallTimeout < 0 || cfg.CommitTimeout < 0 {
+		return nil, fmt.Errorf("download-timeout, install-timeout and commit-timeout must not be negative")
+	}
+	if cfg.RequireApproval && cfg.ApprovalKey == "" {
+		return nil, fmt.Errorf("require-approval requires approval-key")
+	}
+	if cfg.LockKey != "" && cfg.LockTTL < time.Second {
+		return nil, fmt.Errorf("lock-ttl must be at least 1s")
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		return nil, fmt.Errorf("shutdown-timeout must be positive")
+	}
+	if cfg.ArtifactVerifyKey != "" {
+		if _, err := os.Stat(cfg.ArtifactVerifyKey); err != nil {
+			return nil, fmt.Errorf("invalid artifact-verify-key: %w", err)
+		}
+	}
+	for _, component := range strings.Split(cfg.Component, ",") {
+		if component = strings.TrimSpace(component); component != "" {
+			cfg.Components = append(cfg.Components, component)
+		}
+	}
+	// Verifying a local file involves no component
+	if len(cfg.Components) == 0 && cfg.Command != CommandVerify {
+		if !cfg.AllowNoComponent {
+			return nil, fmt.Errorf("component is required (or set allow-empty-component)")
+		}
+		cfg.Component = UnknownComponent
+		cfg.Components = []string{UnknownComponent}
+	}
+	if len(cfg.Components) > 1 {
+		if cfg.WatchMount != "" {
+			return nil, fmt.Errorf("watch-mount cannot be used with several components")
+		}
+		// Components must not take each other's updates, verify against each
+		// other's checksums, overwrite each other's status or share downloads
+		perComponent := []struct {
+			name, value string
+		}{
+			{"update-key", cfg.UpdateKey},
+			{"checksum-key", cfg.ChecksumKey},
+			{"failure-key", cfg.FailureKey},
+			{"ota-hash-key", cfg.OTAHashKey},
+			{"lock-key", cfg.LockKey},
+			{"download-dir", cfg.DownloadDir},
+			{"staging-dir", cfg.StagingDir},
+		}
+		for _, setting := range perComponent {
+			if setting.value != "" && !strings.Contains(setting.value, ComponentPlaceholder) {
+				return nil, fmt.Errorf("%s must contain %s when several components are configured", setting.name, ComponentPlaceholder)
+			}
+		}
+	}
+
+	if cfg.PublishPayload != "field" && cfg.PublishPayload != "json" {
+		return nil, fmt.Errorf("invalid publish-payload '%s', must be 'field' or 'json'", cfg.PublishPayload)
+	}
+
+	if _, err := logging.ParseLevel(cfg.LogLevel); err != nil {
+		return nil, err
 	}
 
 	// Validate update-type
@@ -61,5 +450,97 @@ func Parse() (*Config, error) {
 		return nil, fmt.Errorf("invalid update-type '%s', must be 'blocking' or 'non-blocking'", cfg.UpdateType)
 	}
 
+	// Fail fast instead of mid-download if artifacts cannot be stored.
+	// Subcommands don't download.
+	if cfg.Command == "" {
+		for _, component := range cfg.Components {
+			componentCfg := cfg.ForComponent(component)
+			if err := checkWritableDir(componentCfg.DownloadDir); err != nil {
+				return nil, fmt.Errorf("invalid download-dir: %w", err)
+			}
+			if componentCfg.StagingDir != "" {
+				if err := checkWritableDir(componentCfg.StagingDir); err != nil {
+					return nil, fmt.Errorf("invalid staging-dir: %w", err)
+				}
+			}
+		}
+	}
+
 	return cfg, nil
 }
+
+// Subcommands run instead of the update daemon
+const (
+	CommandStatus = "status" // print the OTA status from Redis as JSON and exit
+	CommandCheck  = "check"  // print the queued update without taking it and exit
+	CommandVerify = "verify" // check a local file against a checksum and exit
+)
+
+// UnknownComponent is the component name used with --allow-empty-component
+// when no component is configured
+const UnknownComponent = "unknown"
+
+// ComponentPlaceholder is replaced with the component name in keys and paths
+// when several components are handled by one process
+const ComponentPlaceholder = "{component}"
+
+// ForComponent returns a copy of the configuration for a single component,
+// with ComponentPlaceholder replaced by its name in the update, checksum,
+// failure, OTA hash, lock, approval, pause and status history keys, the command
+// channel and the download and staging directories
+func (c *Config) ForComponent(component string) *Config {
+	cc := *c
+	cc.Component = component
+	cc.Components = []string{component}
+	for _, field := range []*string{&cc.UpdateKey, &cc.ChecksumKey, &cc.FailureKey, &cc.OTAHashKey, &cc.LockKey, &cc.CommandChannel, &cc.ApprovalKey, &cc.PauseKey, &cc.StatusHistoryKey, &cc.DownloadDir, &cc.StagingDir} {
+		*field = strings.ReplaceAll(*field, ComponentPlaceholder, component)
+	}
+	return &cc
+}
+
+// applyKeyPrefix prepends KeyPrefix to every configured Redis key and
+// channel. The OTA hash key is also the channel status changes are published
+// on, so it is covered too. Empty keys stay disabled.
+func (cfg *Config) applyKeyPrefix() {
+	if cfg.KeyPrefix == "" {
+		return
+	}
+	for _, key := range []*string{&cfg.UpdateKey, &cfg.ChecksumKey, &cfg.FailureKey, &cfg.OTAHashKey, &cfg.LockKey, &cfg.CommandChannel, &cfg.ApprovalKey, &cfg.PauseKey, &cfg.StatusHistoryKey} {
+		if *key != "" {
+			*key = cfg.KeyPrefix + *key
+		}
+	}
+}
+
+// checkWritableDir makes sure dir exists, creating it if needed, and that
+// files can be created and removed in it
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot create %s: %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, ".smut-write-test-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	name := f.Name()
+	f.Close()
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("cannot remove files in %s: %w", dir, err)
+	}
+	return nil
+}
+
+// String formats the configuration for logging with secrets redacted
+func (c *Config) String() string {
+	type plain Config
+	redacted := plain(*c)
+	for _, secret := range []*string{&redacted.RedisPassword, &redacted.AuthBearer, &redacted.AuthBasic, &redacted.S3SecretKey} {
+		if *secret != "" {
+			*secret = "REDACTED"
+		}
+	}
+	if redacted.Proxy != "" {
+		redacted.Proxy = logging.RedactURL(redacted.Proxy)
+	}
+	return fmt.Sprintf("%+v", redacted)
+}
@@ -0,0 +1,34 @@
+package config
+
+import "testing"
+
+func TestEnvName(t *testing.T) {
+	if got := envName("redis-addr"); got != "SMUT_REDIS_ADDR" {
+		t.Errorf("envName(redis-addr) = %s", got)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("SMUT_COMPONENT", "mdb")
+	t.Setenv("SMUT_REDIS_ADDR", "redis:6379")
+	t.Setenv("SMUT_DOWNLOAD_DIR", t.TempDir())
+
+	// Flags take precedence over the environment
+	cfg, err := parseArgs("--redis-addr", "localhost:6380")
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+	if cfg.Component != "mdb" {
+		t.Errorf("Component = %q, want mdb from the environment", cfg.Component)
+	}
+	if cfg.RedisAddr != "localhost:6380" {
+		t.Errorf("RedisAddr = %q, want the flag value", cfg.RedisAddr)
+	}
+}
+
+func TestLoadEnvInvalidValue(t *testing.T) {
+	t.Setenv("SMUT_REDIS_DB", "one")
+	if _, err := parseArgs("--component", "dbc", "--download-dir", t.TempDir()); err == nil {
+		t.Error("parse() accepted an invalid SMUT_REDIS_DB")
+	}
+}
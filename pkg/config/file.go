@@ -0,0 +1,50 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// loadFile applies the settings from a YAML config file to flags that were
+// not given on the command line or in the environment. Keys are the flag names without dashes,
+// e.g. "redis-addr: localhost:6379", so flags and environment variables
+// override the file and the file overrides the built-in defaults.
+func loadFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.UnmarshalStrict(data, &values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for name, value := range values {
+		f := fs.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("unknown setting '%s' in config file %s", name, path)
+		}
+		if set[name] {
+			continue
+		}
+		switch value.(type) {
+		case map[interface{}]interface{}, []interface{}:
+			return fmt.Errorf("setting '%s' in config file %s must be a scalar", name, path)
+		case nil:
+			continue
+		}
+		if err := f.Value.Set(fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("invalid value for '%s' in config file %s: %w", name, path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "smut.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	path := writeConfigFile(t, "component: dbc\nredis-addr: redis:6379\nredis-db: 3\ndownload-dir: "+t.TempDir()+"\n")
+	t.Setenv("SMUT_REDIS_DB", "5")
+
+	// Flags and the environment take precedence over the file
+	cfg, err := parseArgs("--config", path, "--redis-addr", "localhost:6380")
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+	if cfg.Component != "dbc" {
+		t.Errorf("Component = %q, want dbc from the file", cfg.Component)
+	}
+	if cfg.RedisAddr != "localhost:6380" {
+		t.Errorf("RedisAddr = %q, want the flag value", cfg.RedisAddr)
+	}
+	if cfg.RedisDB != 5 {
+		t.Errorf("RedisDB = %d, want 5 from the environment", cfg.RedisDB)
+	}
+}
+
+func TestLoadFileRejectsBadSettings(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown setting": "redis-adress: redis:6379\n",
+		"nested value":    "redis-addr:\n  host: redis\n",
+		"invalid value":   "redis-db: one\n",
+		"config file":     "config: other.yaml\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfigFile(t, content)
+			if _, err := parseArgs("--config", path, "--component", "dbc", "--download-dir", t.TempDir()); err == nil {
+				t.Error("parse() accepted the config file")
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package download
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// cacheDirName is the subdirectory of the download directory holding cached
+// artifacts, named by their digest. Being a directory, it is never touched by
+// CleanStale.
+const cacheDirName = "cache"
+
+// SetCacheMaxBytes keeps up to maxBytes of verified artifacts, keyed by
+// checksum, so a device switching back to a recent release does not download
+// it again. The least recently used artifacts are evicted first. 0 disables
+// the cache.
+func (m *Manager) SetCacheMaxBytes(maxBytes int64) error {
+	m.cacheMaxBytes = maxBytes
+	if maxBytes <= 0 {
+		return nil
+	}
+	if err := os.MkdirAll(m.cacheDir(), 0755); err != nil {
+		return fmt.Errorf("error creating artifact cache directory: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) cacheDir() string {
+	return filepath.Join(m.downloadDir, cacheDirName)
+}
+
+// cachePath returns the path an artifact with checksum is cached under, or
+// "" if checksum is not in the algorithm:hash form
+func (m *Manager) cachePath(checksum string) string {
+	_, digest, err := ParseChecksum(checksum)
+	if err != nil {
+		return ""
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return ""
+	}
+	return filepath.Join(m.cacheDir(), strings.ToLower(digest)+".mender")
+}
+
+// fromCache returns the artifact identified by the checksum set with
+// SetArtifactID from the cache, linked into the download directory under the
+// name a download of url would get. The cached file is verified against the
+// checksum again, and written to digest if non-nil. ok is false on a miss.
+func (m *Manager) fromCache(url string, digest hash.Hash) (path string, ok bool) {
+	if m.cacheMaxBytes <= 0 || m.artifactID == "" || IsLocalURL(url) {
+		return "", false
+	}
+	cached := m.cachePath(m.artifactID)
+	if cached == "" {
+		return "", false
+	}
+	if _, err := os.Stat(cached); err != nil {
+		return "", false
+	}
+
+	if err := verifyCached(cached, m.artifactID, digest); err != nil {
+		logging.Warnf("Discarding cached artifact %s: %v", cached, err)
+		os.Remove(cached)
+		return "", false
+	}
+
+	path = filepath.Join(m.downloadDir, sanitizeFilename(url))
+	if err := linkFile(cached, path); err != nil {
+		logging.Warnf("Failed to use cached artifact %s: %v", cached, err)
+		return "", false
+	}
+	// The modification time orders cache entries for eviction
+	now := time.Now()
+	os.Chtimes(cached, now, now)
+	logging.Infof("Using cached artifact %s", cached)
+	return path, true
+}
+
+// verifyCached checks the cached file at path against checksum, writing its
+// contents to digest as well if non-nil
+func verifyCached(path, checksum string, digest hash.Hash) error {
+	algorithm, _, err := ParseChecksum(checksum)
+	if err != nil {
+		return err
+	}
+	verify, err := newHash(algorithm)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = verify
+	if digest != nil {
+		digest.Reset()
+		w = io.MultiWriter(verify, digest)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.Copy(w, file); err != nil {
+		return fmt.Errorf("error calculating checksum: %w", err)
+	}
+	return CompareDigest(hex.EncodeToString(verify.Sum(nil)), checksum)
+}
+
+// CacheArtifact adds the downloaded file at path, already verified against
+// checksum, to the cache and evicts the least recently used artifacts beyond
+// the byte budget. It does nothing if the cache is disabled or checksum is
+// not in the algorithm:hash form.
+func (m *Manager) CacheArtifact(path, checksum string) error {
+	if m.cacheMaxBytes <= 0 {
+		return nil
+	}
+	cached := m.cachePath(checksum)
+	if cached == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error caching artifact: %w", err)
+	}
+	if info.Size() > m.cacheMaxBytes {
+		logging.Infof("Not caching %s, its %d bytes exceed the cache size of %d bytes", path, info.Size(), m.cacheMaxBytes)
+		return nil
+	}
+
+	if err := linkFile(path, cached); err != nil {
+		return fmt.Errorf("error caching artifact: %w", err)
+	}
+	now := time.Now()
+	os.Chtimes(cached, now, now)
+	logging.Infof("Cached artifact %s as %s", path, cached)
+	return m.evictCache(filepath.Base(cached))
+}
+
+// evictCache removes the least recently used cached artifacts other than
+// keep until the cache fits its byte budget
+func (m *Manager) evictCache(keep string) error {
+	entries, err := os.ReadDir(m.cacheDir())
+	if err != nil {
+		return fmt.Errorf("error reading artifact cache directory: %w", err)
+	}
+
+	var files []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	for _, info := range files {
+		if total <= m.cacheMaxBytes {
+			break
+		}
+		if info.Name() == keep {
+			continue
+		}
+		path := filepath.Join(m.cacheDir(), info.Name())
+		if err := os.Remove(path); err != nil {
+			logging.Warnf("Failed to evict cached artifact %s: %v", path, err)
+			continue
+		}
+		logging.Infof("Evicted cached artifact %s (last used %s)", path, info.ModTime().Format(time.RFC3339))
+		total -= info.Size()
+	}
+	return nil
+}
+
+// linkFile makes dst a hard link to src, replacing dst, so a cached artifact
+// takes no extra space. Filesystems without hard links get a copy.
+func linkFile(src, dst string) error {
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
@@ -0,0 +1,133 @@
+package download
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownloadServedFromCache(t *testing.T) {
+	const body = "release 2"
+	server, served := artifactServer(t, body)
+	m := NewManager(t.TempDir())
+	if err := m.SetCacheMaxBytes(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	checksum := sha256Checksum(body)
+
+	// Download, verify and cache, then remove it like after an install
+	m.SetArtifactID(checksum)
+	path, err := m.Download(context.Background(), server.URL+"/a.mender")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if err := m.CacheArtifact(path, checksum); err != nil {
+		t.Fatalf("CacheArtifact: %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same checksum under another URL comes from the cache
+	m.SetArtifactID(checksum)
+	path, digest, err := m.DownloadWithChecksum(context.Background(), server.URL+"/b.mender?token=refreshed", "sha256")
+	if err != nil {
+		t.Fatalf("second Download: %v", err)
+	}
+	if n := served.Load(); n != 1 {
+		t.Errorf("server sent the artifact %d times, want 1", n)
+	}
+	if err := CompareDigest(digest, checksum); err != nil {
+		t.Errorf("digest of cached artifact: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != body {
+		t.Errorf("cached artifact holds %q, want %q", data, body)
+	}
+}
+
+func TestCorruptCacheEntryIsDownloadedAgain(t *testing.T) {
+	const body = "release 2"
+	server, served := artifactServer(t, body)
+	m := NewManager(t.TempDir())
+	if err := m.SetCacheMaxBytes(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	checksum := sha256Checksum(body)
+	if err := os.WriteFile(m.cachePath(checksum), []byte("bit rot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m.SetArtifactID(checksum)
+	path, err := m.Download(context.Background(), server.URL+"/a.mender")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if n := served.Load(); n != 1 {
+		t.Errorf("server sent the artifact %d times, want 1", n)
+	}
+	if data, _ := os.ReadFile(path); string(data) != body {
+		t.Errorf("artifact holds %q, want %q", data, body)
+	}
+	if _, err := os.Stat(m.cachePath(checksum)); !os.IsNotExist(err) {
+		t.Errorf("corrupt cache entry kept: %v", err)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir)
+	// Room for two of the three artifacts
+	if err := m.SetCacheMaxBytes(20); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := []string{"artifact1", "artifact2", "artifact3"}
+	base := time.Now().Add(-time.Hour)
+	for i, body := range bodies[:2] {
+		path := filepath.Join(dir, body+".mender")
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.CacheArtifact(path, sha256Checksum(body)); err != nil {
+			t.Fatalf("CacheArtifact: %v", err)
+		}
+		// Artifact 2 was used last
+		used := base.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(m.cachePath(sha256Checksum(body)), used, used)
+	}
+
+	path := filepath.Join(dir, "artifact3.mender")
+	if err := os.WriteFile(path, []byte(bodies[2]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CacheArtifact(path, sha256Checksum(bodies[2])); err != nil {
+		t.Fatalf("CacheArtifact: %v", err)
+	}
+
+	for i, body := range bodies {
+		_, err := os.Stat(m.cachePath(sha256Checksum(body)))
+		if cached, want := err == nil, i > 0; cached != want {
+			t.Errorf("%s cached = %v, want %v", body, cached, want)
+		}
+	}
+}
+
+func TestCacheSkipsOversizedArtifact(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir)
+	if err := m.SetCacheMaxBytes(4); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "a.mender")
+	if err := os.WriteFile(path, []byte("too large"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CacheArtifact(path, sha256Checksum("too large")); err != nil {
+		t.Fatalf("CacheArtifact: %v", err)
+	}
+	if _, err := os.Stat(m.cachePath(sha256Checksum("too large"))); !os.IsNotExist(err) {
+		t.Errorf("oversized artifact cached: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// requestLog records the requests a test server received
+type requestLog struct {
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (l *requestLog) record(r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests = append(l.requests, r.Clone(context.Background()))
+}
+
+func (l *requestLog) all() []*http.Request {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*http.Request(nil), l.requests...)
+}
+
+func TestDownloadCacheGetsNoCredentials(t *testing.T) {
+	const body = "release 2"
+	var cacheLog, originLog requestLog
+	cache := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cacheLog.record(r)
+		w.Write([]byte(body))
+	}))
+	defer cache.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originLog.record(r)
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, ".sha256") {
+			w.Write([]byte(strings.Repeat("ab", 32)))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer origin.Close()
+
+	m := NewManager(t.TempDir())
+	m.SetCacheURL(cache.URL + "/fetch")
+	m.SetAuth("secret", "")
+	ctx := context.Background()
+
+	// A plain URL goes through the cache, without the origin's credentials
+	if _, err := m.Download(ctx, origin.URL+"/a.mender"); err != nil {
+		t.Fatalf("Download through the cache: %v", err)
+	}
+	if _, err := m.RemoteSidecarChecksum(ctx, origin.URL+"/a.mender", ".sha256"); err != nil {
+		t.Fatalf("RemoteSidecarChecksum through the cache: %v", err)
+	}
+	for _, r := range cacheLog.all() {
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("cache got Authorization %q for %s", auth, r.URL)
+		}
+	}
+	if len(cacheLog.all()) == 0 || len(originLog.all()) != 0 {
+		t.Fatalf("cache got %d and origin %d requests, want only the cache", len(cacheLog.all()), len(originLog.all()))
+	}
+
+	// A presigned URL never reaches the cache, not even as the target
+	cacheLog = requestLog{}
+	if _, err := m.Download(ctx, origin.URL+"/b.mender?X-Amz-Signature=abc"); err != nil {
+		t.Fatalf("Download of a presigned URL: %v", err)
+	}
+	if _, err := m.RemoteSidecarChecksum(ctx, origin.URL+"/b.mender?X-Amz-Signature=abc", ".sha256"); err != nil {
+		t.Fatalf("RemoteSidecarChecksum of a presigned URL: %v", err)
+	}
+	if n := len(cacheLog.all()); n != 0 {
+		t.Errorf("cache got %d requests for a presigned URL", n)
+	}
+	if len(originLog.all()) == 0 {
+		t.Error("presigned URL was not fetched from the origin")
+	}
+}
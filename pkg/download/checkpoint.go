@@ -0,0 +1,153 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+const (
+	// checkpointChunkSize is the granularity at which partial downloads are hashed
+	checkpointChunkSize = 4 * 1024 * 1024
+	// checkpointExt is appended to the partial file path to name its checkpoint
+	checkpointExt = ".chk"
+)
+
+// checkpoint records the hashes of the complete chunks of a partial download
+// that were synced to disk, so a resume can detect a corrupt partial without
+// relying on the final checksum.
+type checkpoint struct {
+	ChunkSize int64    `json:"chunk_size"`
+	Chunks    []string `json:"chunks"`
+}
+
+// chunkTracker hashes downloaded bytes in fixed-size chunks
+type chunkTracker struct {
+	chunks  []string
+	current hash.Hash
+	filled  int64
+}
+
+func newChunkTracker() *chunkTracker {
+	return &chunkTracker{current: sha256.New()}
+}
+
+// Write feeds downloaded bytes into the tracker
+func (t *chunkTracker) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := checkpointChunkSize - t.filled
+		if int64(len(p)) < n {
+			n = int64(len(p))
+		}
+		t.current.Write(p[:n])
+		t.filled += n
+		p = p[n:]
+		if t.filled == checkpointChunkSize {
+			t.chunks = append(t.chunks, hex.EncodeToString(t.current.Sum(nil)))
+			t.current.Reset()
+			t.filled = 0
+		}
+	}
+	return written, nil
+}
+
+// save writes the checkpoint for the complete chunks seen so far
+func (t *chunkTracker) save(path string) error {
+	data, err := json.Marshal(checkpoint{ChunkSize: checkpointChunkSize, Chunks: t.chunks})
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".new"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// loadCheckpoint reads a checkpoint file, returning nil if there is none
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	if cp.ChunkSize != checkpointChunkSize {
+		return nil, fmt.Errorf("unexpected chunk size %d", cp.ChunkSize)
+	}
+	return &cp, nil
+}
+
+// resumeFromCheckpoint validates the partial file at path against its
+// checkpoint and returns a tracker seeded with the partial's chunk hashes,
+// along with the offset to resume from. The partial is truncated to the last
+// chunk that was checkpointed and verified, stopping at the first corrupt
+// chunk. Without a checkpoint the whole partial is trusted and hashed. The
+// retained bytes are also written to seed, if non-nil.
+func resumeFromCheckpoint(path string, size int64, seed io.Writer) (*chunkTracker, int64, error) {
+	tracker := newChunkTracker()
+	var w io.Writer = tracker
+	if seed != nil {
+		w = io.MultiWriter(tracker, seed)
+	}
+	checkpointPath := path + checkpointExt
+
+	if size == 0 {
+		os.Remove(checkpointPath)
+		return tracker, 0, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening partial file: %w", err)
+	}
+	defer file.Close()
+
+	cp, err := loadCheckpoint(checkpointPath)
+	if err != nil {
+		logging.Warnf("Ignoring unreadable checkpoint %s: %v", checkpointPath, err)
+	}
+	if cp == nil {
+		if _, err := io.Copy(w, file); err != nil {
+			return nil, 0, fmt.Errorf("error reading partial file: %w", err)
+		}
+		return tracker, size, nil
+	}
+
+	chunk := make([]byte, checkpointChunkSize)
+	for i, expected := range cp.Chunks {
+		if int64(i+1)*checkpointChunkSize > size {
+			break
+		}
+		if _, err := io.ReadFull(file, chunk); err != nil {
+			return nil, 0, fmt.Errorf("error reading partial file: %w", err)
+		}
+		sum := sha256.Sum256(chunk)
+		if hex.EncodeToString(sum[:]) != expected {
+			logging.Warnf("Partial file is corrupt at chunk %d, discarding it and everything after", i)
+			break
+		}
+		w.Write(chunk)
+	}
+
+	valid := int64(len(tracker.chunks)) * checkpointChunkSize
+	if valid < size {
+		logging.Infof("Truncating partial file from %d to %d verified bytes", size, valid)
+		if err := file.Truncate(valid); err != nil {
+			return nil, 0, fmt.Errorf("error truncating partial file: %w", err)
+		}
+	}
+	return tracker, valid, nil
+}
@@ -0,0 +1,114 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// checkpointBody returns an artifact of two checkpoint chunks and a tail,
+// with bytes that differ between chunks
+func checkpointBody() []byte {
+	body := make([]byte, 2*checkpointChunkSize+100)
+	for i := range body {
+		body[i] = byte(i / 251)
+	}
+	return body
+}
+
+// writeCheckpointedPartial leaves the first n bytes of body as a partial
+// download of filename in dir, with a checkpoint of its complete chunks
+func writeCheckpointedPartial(t *testing.T, dir, filename string, body []byte, n int) string {
+	t.Helper()
+	path := writePartial(t, dir, filename, string(body[:n]))
+	tracker := newChunkTracker()
+	tracker.Write(body[:n])
+	if err := tracker.save(path + checkpointExt); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// rangeLogServer serves body like rangeServer and records the requests
+func rangeLogServer(t *testing.T, body []byte) (*httptest.Server, *requestLog) {
+	t.Helper()
+	log := &requestLog{}
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		http.ServeContent(w, r, "update.mender", modTime, bytes.NewReader(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, log
+}
+
+// getRanges returns the Range headers of the GET requests in log
+func getRanges(log *requestLog) []string {
+	var ranges []string
+	for _, r := range log.all() {
+		if r.Method == http.MethodGet {
+			ranges = append(ranges, r.Header.Get("Range"))
+		}
+	}
+	return ranges
+}
+
+func TestResumeTruncatesCorruptPartial(t *testing.T) {
+	body := checkpointBody()
+	server, log := rangeLogServer(t, body)
+	dir := t.TempDir()
+	path := writeCheckpointedPartial(t, dir, "update.mender", body, 2*checkpointChunkSize+50)
+
+	// Flip a byte in the second chunk, as a power cut might
+	partial, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial.WriteAt([]byte{^body[checkpointChunkSize+10]}, checkpointChunkSize+10)
+	partial.Close()
+
+	m := NewManager(dir)
+	m.SetCheckpoints(true)
+	got, err := m.Download(context.Background(), server.URL+"/update.mender")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if data, _ := os.ReadFile(got); !bytes.Equal(data, body) {
+		t.Fatal("downloaded artifact differs from the served one")
+	}
+	want := "bytes=4194304-"
+	if ranges := getRanges(log); len(ranges) != 1 || ranges[0] != want {
+		t.Errorf("GET ranges = %q, want a resume at the corrupt chunk with %s", ranges, want)
+	}
+}
+
+func TestNoResumeDiscardsPartial(t *testing.T) {
+	body := checkpointBody()
+	server, log := rangeLogServer(t, body)
+	dir := t.TempDir()
+	path := writeCheckpointedPartial(t, dir, "update.mender", body, checkpointChunkSize+50)
+
+	m := NewManager(dir)
+	m.SetCheckpoints(true)
+	m.SetResume(false)
+	got, err := m.Download(context.Background(), server.URL+"/update.mender")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if data, _ := os.ReadFile(got); !bytes.Equal(data, body) {
+		t.Fatal("downloaded artifact differs from the served one")
+	}
+	if ranges := getRanges(log); len(ranges) != 1 || ranges[0] != "" {
+		t.Errorf("GET ranges = %q, want one download from scratch", ranges)
+	}
+	for _, leftover := range []string{path, path + checkpointExt} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", filepath.Base(leftover), err)
+		}
+	}
+}
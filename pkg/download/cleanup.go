@@ -0,0 +1,89 @@
+package download
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// staleSuffixes are the names of files the manager leaves behind in the
+// download directory. Other files are never touched, since the directory may
+// be shared (e.g. /tmp).
+var staleSuffixes = []string{
+	".mender",
+	".mender" + cacheExt,
+	partialExt,
+	partialExt + checkpointExt,
+	partialExt + metaExt,
+}
+
+// isDownloadFile reports whether name looks like a file created by the manager
+func isDownloadFile(name string) bool {
+	for _, suffix := range staleSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// setActive records the filename of the download in progress, empty when idle
+func (m *Manager) setActive(filename string) {
+	m.activeMu.Lock()
+	m.active = filename
+	m.activeMu.Unlock()
+}
+
+// isActive reports whether name belongs to the download in progress
+func (m *Manager) isActive(name string) bool {
+	m.activeMu.Lock()
+	defer m.activeMu.Unlock()
+	return m.active != "" && strings.HasPrefix(name, m.active)
+}
+
+// CleanStale removes artifacts and partial downloads from the download and
+// staging directories that have not been modified for maxAge, e.g. leftovers of
+// downloads that were interrupted and never resumed. Files of the active
+// download and files modified more recently, which may still be written, are
+// kept. It returns the number of removed files.
+func (m *Manager) CleanStale(maxAge time.Duration) (int, error) {
+	removed, err := m.cleanStaleDir(m.downloadDir, maxAge)
+	if err != nil || m.stagingDir == "" || m.stagingDir == m.downloadDir {
+		return removed, err
+	}
+	staged, err := m.cleanStaleDir(m.stagingDir, maxAge)
+	return removed + staged, err
+}
+
+// cleanStaleDir removes the stale downloads in dir for CleanStale
+func (m *Manager) cleanStaleDir(dir string, maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("error reading download directory: %w", err)
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !isDownloadFile(name) || m.isActive(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil {
+			logging.Warnf("Failed to remove stale download %s: %v", path, err)
+			continue
+		}
+		logging.Infof("Removed stale download %s (last modified %s)", path, info.ModTime().Format(time.RFC3339))
+		removed++
+	}
+	return removed, nil
+}
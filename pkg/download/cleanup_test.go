@@ -0,0 +1,51 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCleanStale(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	files := []struct {
+		name     string
+		old      bool
+		wantKept bool
+	}{
+		{name: "old.mender", old: true},
+		{name: "old.mender" + partialExt, old: true},
+		{name: "old.mender" + partialExt + metaExt, old: true},
+		{name: "recent.mender", wantKept: true},
+		{name: "recent.mender" + partialExt, wantKept: true},
+		// The directory may be shared, other files are never touched
+		{name: "notes.txt", old: true, wantKept: true},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if f.old {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	removed, err := NewManager(dir).CleanStale(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("CleanStale: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("CleanStale removed %d files, want 3", removed)
+	}
+	for _, f := range files {
+		_, err := os.Stat(filepath.Join(dir, f.name))
+		if kept := err == nil; kept != f.wantKept {
+			t.Errorf("%s kept = %v, want %v", f.name, kept, f.wantKept)
+		}
+	}
+}
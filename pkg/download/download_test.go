@@ -0,0 +1,507 @@
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// artifactServer serves body at any path and counts the GET requests
+// answered with it
+func artifactServer(t *testing.T, body string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var served atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v2"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.Method == http.MethodGet {
+			served.Add(1)
+		}
+		w.Header().Set("ETag", `"v2"`)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &served
+}
+
+func sha256Checksum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestDownloadReusesCompletedFile(t *testing.T) {
+	const current = "release 2"
+	tests := []struct {
+		name       string
+		onDisk     string
+		validators bool
+		checksum   string
+		wantServed int32
+	}{
+		{name: "stale file without checksum", onDisk: "release 1", wantServed: 1},
+		{name: "stale file with checksum", onDisk: "release 1", checksum: sha256Checksum(current), wantServed: 1},
+		{name: "current file with checksum", onDisk: current, checksum: sha256Checksum(current), wantServed: 0},
+		{name: "file with validators", onDisk: current, validators: true, wantServed: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, served := artifactServer(t, current)
+			dir := t.TempDir()
+			path := filepath.Join(dir, "update.mender")
+			if err := os.WriteFile(path, []byte(tt.onDisk), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.validators {
+				if err := os.WriteFile(path+cacheExt, []byte(`{"etag":"\"v2\""}`), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			m := NewManager(dir)
+			m.SetArtifactID(tt.checksum)
+			got, err := m.Download(context.Background(), server.URL+"/update.mender?token=abc")
+			if err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			if got != path {
+				t.Errorf("Download = %s, want %s", got, path)
+			}
+			data, _ := os.ReadFile(got)
+			if string(data) != current {
+				t.Errorf("downloaded file holds %q, want %q", data, current)
+			}
+			if n := served.Load(); n != tt.wantServed {
+				t.Errorf("server sent the artifact %d times, want %d", n, tt.wantServed)
+			}
+		})
+	}
+}
+
+func TestDownloadRevalidatesWithServer(t *testing.T) {
+	var release atomic.Int32
+	release.Store(1)
+	var served, notModified atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"v%d"`, release.Load())
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.Method == http.MethodGet {
+			served.Add(1)
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprintf(w, "release %d", release.Load())
+	}))
+	defer server.Close()
+	m := NewManager(t.TempDir())
+
+	steps := []struct {
+		release    int32
+		want       string
+		wantServed int32
+		wantNotMod int32
+	}{
+		{release: 1, want: "release 1", wantServed: 1},
+		// The stored ETag still matches, the server answers 304
+		{release: 1, want: "release 1", wantServed: 1, wantNotMod: 1},
+		// A new release under the same URL is downloaded again
+		{release: 2, want: "release 2", wantServed: 2, wantNotMod: 1},
+	}
+	for i, step := range steps {
+		release.Store(step.release)
+		path, err := m.Download(context.Background(), server.URL+"/update.mender")
+		if err != nil {
+			t.Fatalf("step %d: Download: %v", i, err)
+		}
+		if got := readDownload(t, path); got != step.want {
+			t.Errorf("step %d: downloaded %q, want %q", i, got, step.want)
+		}
+		if served.Load() != step.wantServed || notModified.Load() != step.wantNotMod {
+			t.Errorf("step %d: server sent the artifact %d times and 304 %d times, want %d and %d",
+				i, served.Load(), notModified.Load(), step.wantServed, step.wantNotMod)
+		}
+	}
+}
+
+func TestDownloadReusesFileSavedUnderDispositionName(t *testing.T) {
+	const current = "release 2"
+	var served atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			served.Add(1)
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="release-2.mender"`)
+		w.Write([]byte(current))
+	}))
+	t.Cleanup(server.Close)
+	dir := t.TempDir()
+
+	m := NewManager(dir)
+	m.SetArtifactID(sha256Checksum(current))
+	for i := 0; i < 2; i++ {
+		got, err := m.Download(context.Background(), server.URL+"/download?id=2")
+		if err != nil {
+			t.Fatalf("Download: %v", err)
+		}
+		if want := filepath.Join(dir, "release-2.mender"); got != want {
+			t.Errorf("Download = %s, want %s", got, want)
+		}
+	}
+	if n := served.Load(); n != 1 {
+		t.Errorf("server sent the artifact %d times, want 1", n)
+	}
+}
+
+func TestDispositionFilename(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: `attachment; filename="release-2.mender"`, want: "release-2.mender"},
+		{header: `attachment; filename*=UTF-8''release%202.mender`, want: "release_2.mender"},
+		{header: `attachment; filename="../../etc/passwd"`, want: "passwd"},
+		{header: `attachment; filename="C:\\temp\\a.mender"`, want: "a.mender"},
+		{header: `attachment; filename=".."`, want: ""},
+		{header: `attachment`, want: ""},
+		{header: `attachment; filename="unterminated`, want: ""},
+		{header: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := dispositionFilename(tt.header); got != tt.want {
+			t.Errorf("dispositionFilename(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadWithChecksumDigestOfReusedFile(t *testing.T) {
+	const current = "release 2"
+	server, _ := artifactServer(t, current)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "update.mender"), []byte("release 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(dir)
+	m.SetArtifactID(sha256Checksum(current))
+	_, digest, err := m.DownloadWithChecksum(context.Background(), server.URL+"/update.mender", "sha256")
+	if err != nil {
+		t.Fatalf("DownloadWithChecksum: %v", err)
+	}
+	if err := CompareDigest(digest, sha256Checksum(current)); err != nil {
+		t.Fatalf("digest after replacing a stale file: %v", err)
+	}
+}
+
+func TestDownloadAuth(t *testing.T) {
+	tests := []struct {
+		name          string
+		bearer, basic string
+		userinfo      string
+		wantErr       bool
+	}{
+		{name: "bearer token", bearer: "secret"},
+		{name: "basic auth", basic: "user:secret"},
+		{name: "credentials in the URL", userinfo: "user:secret@"},
+		{name: "bearer token takes precedence", bearer: "secret", basic: "user:wrong"},
+		{name: "no credentials", wantErr: true},
+		{name: "wrong password", basic: "user:wrong", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user, password, ok := r.BasicAuth()
+				if r.Header.Get("Authorization") != "Bearer secret" && (!ok || user != "user" || password != "secret") {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Write([]byte("release 2"))
+			}))
+			defer server.Close()
+
+			m := NewManager(t.TempDir())
+			m.SetAuth(tt.bearer, tt.basic)
+			url := strings.Replace(server.URL, "://", "://"+tt.userinfo, 1) + "/update.mender"
+			_, err := m.Download(context.Background(), url)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "401") {
+					t.Errorf("Download = %v, want a 401 error", err)
+				}
+			} else if err != nil {
+				t.Errorf("Download: %v", err)
+			}
+		})
+	}
+}
+
+func TestDownloadThroughProxy(t *testing.T) {
+	var proxied requestLog
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.record(r)
+		w.Write([]byte("release 2"))
+	}))
+	defer proxy.Close()
+
+	m := NewManager(t.TempDir())
+	if err := m.SetProxy(proxy.URL); err != nil {
+		t.Fatalf("SetProxy: %v", err)
+	}
+	// The host does not resolve, only the proxy can reach it
+	if _, err := m.Download(context.Background(), "http://artifacts.invalid/update.mender"); err != nil {
+		t.Fatalf("Download through the proxy: %v", err)
+	}
+	requests := proxied.all()
+	if len(requests) == 0 {
+		t.Fatal("proxy got no requests")
+	}
+	for _, r := range requests {
+		if r.Host != "artifacts.invalid" || r.URL.Path != "/update.mender" {
+			t.Errorf("proxy got a request for %s%s, want artifacts.invalid/update.mender", r.Host, r.URL.Path)
+		}
+	}
+
+	if err := m.SetProxy("http://[::1"); err == nil {
+		t.Error("SetProxy accepted an invalid URL")
+	}
+}
+
+func TestDownloadFromMirrors(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	good, served := artifactServer(t, "release 2")
+	dir := t.TempDir()
+	m := NewManager(dir)
+
+	urls := SplitMirrors(broken.URL + "/update.mender, " + good.URL + "/mirror.mender")
+	path, err := m.DownloadFromMirrors(context.Background(), urls)
+	if err != nil {
+		t.Fatalf("DownloadFromMirrors: %v", err)
+	}
+	// The download is named after the first mirror
+	if want := filepath.Join(dir, "update.mender"); path != want {
+		t.Errorf("DownloadFromMirrors = %s, want %s", path, want)
+	}
+	if n := served.Load(); n != 1 {
+		t.Errorf("second mirror sent the artifact %d times, want 1", n)
+	}
+
+	_, err = m.DownloadFromMirrors(context.Background(), []string{broken.URL + "/a.mender", broken.URL + "/b.mender"})
+	if err == nil || !strings.Contains(err.Error(), "all 2 mirrors failed") {
+		t.Errorf("DownloadFromMirrors with only broken mirrors = %v", err)
+	}
+}
+
+func TestLocalSidecarChecksum(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.mender")
+	digest := strings.Repeat("ab", 32)
+	if err := os.WriteFile(path+".sha256", []byte(digest+" *a.mender\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(dir)
+
+	checksum, err := m.LocalSidecarChecksum(path, ".sha256")
+	if err != nil || checksum != "sha256:"+digest {
+		t.Errorf("LocalSidecarChecksum = %q, %v", checksum, err)
+	}
+	checksum, err = m.LocalSidecarChecksum(filepath.Join(dir, "b.mender"), ".sha256")
+	if err != nil || checksum != "" {
+		t.Errorf("LocalSidecarChecksum without sidecar = %q, %v", checksum, err)
+	}
+}
+
+func TestVerifyChecksumAlgorithms(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.mender")
+	if err := os.WriteFile(path, []byte("release 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		checksum string
+		wantErr  bool
+	}{
+		{checksum: "sha1:9ee79fb1f733276f239298cf0e49dc9e94c824b2"},
+		{checksum: "sha256:2ed4cfc8a1b1cc6631d6b2bf70b00173ac9d8eb3d6626473d005c8ede9582bcc"},
+		{checksum: "sha512:e5266b93f59ab49eb141a140458774a50b77038b2c1155e40c4fa924b5d419449d3f6833ae28a0d54f34edc3fdcfc7483adff2b926c442c79a26734e1f1dc446"},
+		{checksum: "blake2b-256:d7ddc4013e6210eb49c5340b333f2d0c00b8d2dd9ec776b8fd164b3452e7b739"},
+		{checksum: "SHA256:2ED4CFC8A1B1CC6631D6B2BF70B00173AC9D8EB3D6626473D005C8EDE9582BCC"},
+		{checksum: "sha256:" + strings.Repeat("0", 64), wantErr: true},
+		{checksum: "md5:4ba3f4d3b5d6b7e1d4c1f5a0f0b0c0d0", wantErr: true},
+	}
+	m := NewManager(t.TempDir())
+	for _, tt := range tests {
+		if err := m.VerifyChecksum(path, tt.checksum); (err != nil) != tt.wantErr {
+			t.Errorf("VerifyChecksum(%s) = %v, want error %v", tt.checksum, err, tt.wantErr)
+		}
+	}
+
+	err := m.VerifyChecksum(path, sha256Checksum("release 1"))
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) {
+		t.Errorf("VerifyChecksum of a mismatch = %v, want a ChecksumError", err)
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	sha1Hex := strings.Repeat("a", 40)
+	sha256Hex := strings.Repeat("b", 64)
+	sha512Hex := strings.Repeat("c", 128)
+	tests := []struct {
+		in        string
+		algorithm string
+		digest    string
+		wantErr   bool
+	}{
+		{in: "sha256:" + sha256Hex, algorithm: "sha256", digest: sha256Hex},
+		{in: " SHA512 : " + sha512Hex, algorithm: "sha512", digest: sha512Hex},
+		{in: "md5:abc", algorithm: "md5", digest: "abc"},
+		{in: sha1Hex, algorithm: "sha1", digest: sha1Hex},
+		{in: sha256Hex + "\n", algorithm: "sha256", digest: sha256Hex},
+		{in: sha512Hex, algorithm: "sha512", digest: sha512Hex},
+		{in: strings.Repeat("a", 50), wantErr: true},
+		{in: strings.Repeat("z", 64), wantErr: true},
+	}
+	for _, tt := range tests {
+		algorithm, digest, err := ParseChecksum(tt.in)
+		if (err != nil) != tt.wantErr || algorithm != tt.algorithm || digest != tt.digest {
+			t.Errorf("ParseChecksum(%q) = %q, %q, %v", tt.in, algorithm, digest, err)
+		}
+	}
+}
+
+func TestRemoteSidecarChecksum(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/releases/a.mender.sha256" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(digest + "  a.mender\n"))
+	}))
+	defer server.Close()
+	m := NewManager(t.TempDir())
+
+	checksum, err := m.RemoteSidecarChecksum(context.Background(), server.URL+"/releases/a.mender?token=abc", ".sha256")
+	if err != nil || checksum != "sha256:"+digest {
+		t.Errorf("RemoteSidecarChecksum = %q, %v", checksum, err)
+	}
+	checksum, err = m.RemoteSidecarChecksum(context.Background(), server.URL+"/releases/b.mender", ".sha256")
+	if err != nil || checksum != "" {
+		t.Errorf("RemoteSidecarChecksum without sidecar = %q, %v", checksum, err)
+	}
+}
+
+func TestRemoteSidecarChecksumFromMirrors(t *testing.T) {
+	digest := strings.Repeat("cd", 32)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	empty := httptest.NewServer(http.NotFoundHandler())
+	defer empty.Close()
+	sums := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/a.mender.SHA256SUMS" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(strings.Repeat("ef", 32) + "  b.mender\n" + digest + " *a.mender\n"))
+	}))
+	defer sums.Close()
+	m := NewManager(t.TempDir())
+	ctx := context.Background()
+
+	checksum, err := m.RemoteSidecarChecksumFromMirrors(ctx, []string{down.URL + "/a.mender", empty.URL + "/a.mender", sums.URL + "/a.mender"}, ".SHA256SUMS")
+	if err != nil || checksum != "sha256:"+digest {
+		t.Errorf("RemoteSidecarChecksumFromMirrors = %q, %v, want the sidecar of the last mirror", checksum, err)
+	}
+	checksum, err = m.RemoteSidecarChecksumFromMirrors(ctx, []string{empty.URL + "/a.mender", sums.URL + "/c.mender"}, ".SHA256SUMS")
+	if err != nil || checksum != "" {
+		t.Errorf("RemoteSidecarChecksumFromMirrors without sidecars = %q, %v", checksum, err)
+	}
+	if _, err := m.RemoteSidecarChecksumFromMirrors(ctx, []string{down.URL + "/a.mender", empty.URL + "/a.mender"}, ".sha256"); err == nil {
+		t.Error("RemoteSidecarChecksumFromMirrors hid the error of a failing mirror")
+	}
+}
+
+func TestSidecarAlgorithm(t *testing.T) {
+	tests := []struct {
+		suffix string
+		want   string
+	}{
+		{".sha256", "sha256"},
+		{".sha256sum", "sha256"},
+		{".SHA256SUMS", "sha256"},
+		{".sha1", "sha1"},
+		{".sha512sum", "sha512"},
+		{".blake2b-256", "blake2b-256"},
+	}
+	for _, tt := range tests {
+		got, err := SidecarAlgorithm(tt.suffix)
+		if err != nil || got != tt.want {
+			t.Errorf("SidecarAlgorithm(%q) = %q, %v, want %q", tt.suffix, got, err, tt.want)
+		}
+	}
+	for _, suffix := range []string{".md5", ".asc", "sha256", ""} {
+		if got, err := SidecarAlgorithm(suffix); err == nil {
+			t.Errorf("SidecarAlgorithm(%q) = %q, want an error", suffix, got)
+		}
+	}
+}
+
+func TestParseChecksumSidecarListingSeveralFiles(t *testing.T) {
+	a, b := strings.Repeat("aa", 32), strings.Repeat("bb", 32)
+	data := a + "  dir/a.mender\n" + b + " *b.mender\n"
+	if got, err := parseChecksumSidecar(data, "sha256", "b.mender"); err != nil || got != "sha256:"+b {
+		t.Errorf("parseChecksumSidecar = %q, %v", got, err)
+	}
+	if got, err := parseChecksumSidecar(data, "sha256", "a.mender"); err != nil || got != "sha256:"+a {
+		t.Errorf("parseChecksumSidecar of a path = %q, %v", got, err)
+	}
+	if _, err := parseChecksumSidecar(data, "sha256", "c.mender"); err == nil {
+		t.Error("parseChecksumSidecar picked a checksum of another file")
+	}
+}
+
+func TestDownloadUserAgent(t *testing.T) {
+	var log requestLog
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.record(r)
+		if strings.HasSuffix(r.URL.Path, ".sha256") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("release 2"))
+	}))
+	defer server.Close()
+	m := NewManager(t.TempDir())
+	m.SetUserAgent("smut/1.2.3 (mdb)")
+
+	if _, err := m.RemoteSidecarChecksum(context.Background(), server.URL+"/update.mender", ".sha256"); err != nil {
+		t.Fatalf("RemoteSidecarChecksum: %v", err)
+	}
+	if _, err := m.Download(context.Background(), server.URL+"/update.mender"); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	requests := log.all()
+	if len(requests) < 3 {
+		t.Fatalf("server saw %d requests, want the sidecar, HEAD and GET", len(requests))
+	}
+	for _, r := range requests {
+		if got := r.Header.Get("User-Agent"); got != "smut/1.2.3 (mdb)" {
+			t.Errorf("%s %s sent User-Agent %q", r.Method, r.URL.Path, got)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package download
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// isEncoded reports whether a Content-Encoding header means the body has to
+// be decoded before it is the artifact itself
+func isEncoded(encoding string) bool {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	return encoding != "" && encoding != "identity"
+}
+
+// decodeBody undoes the response's Content-Encoding so the artifact is written
+// to disk as served by its origin. Only gzip is supported.
+func decodeBody(body io.Reader, encoding string) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing response: %w", err)
+		}
+		return zr, nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding '%s'", encoding)
+	}
+}
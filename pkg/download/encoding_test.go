@@ -0,0 +1,53 @@
+package download
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDownloadGzipEncoded(t *testing.T) {
+	const body = "release 2, compressed in transit"
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(body))
+	zw.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Sent whether asked for or not, like a misconfigured CDN
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	m := NewManager(t.TempDir())
+	path, digest, err := m.DownloadWithChecksum(context.Background(), server.URL+"/update.mender", "sha256")
+	if err != nil {
+		t.Fatalf("DownloadWithChecksum: %v", err)
+	}
+	// The file and its digest are those of the real artifact
+	if got := readDownload(t, path); got != body {
+		t.Errorf("downloaded %q, want %q", got, body)
+	}
+	if err := CompareDigest(digest, sha256Checksum(body)); err != nil {
+		t.Errorf("digest of the decompressed download: %v", err)
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	for _, encoding := range []string{"", "identity"} {
+		r, err := decodeBody(strings.NewReader("plain"), encoding)
+		if err != nil || r == nil {
+			t.Errorf("decodeBody(%q) = %v, %v", encoding, r, err)
+		}
+	}
+	if _, err := decodeBody(strings.NewReader("not gzip"), "gzip"); err == nil {
+		t.Error("decodeBody accepted a body that is not gzip")
+	}
+	if _, err := decodeBody(strings.NewReader("data"), "br"); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Errorf("decodeBody(br) error = %v, want unsupported", err)
+	}
+}
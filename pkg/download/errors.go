@@ -0,0 +1,27 @@
+package download
+
+import "fmt"
+
+// DownloadError is returned when an artifact could not be fetched, from any
+// of its mirrors
+type DownloadError struct {
+	Err error
+}
+
+func (e *DownloadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// ChecksumError is returned when an artifact does not match its expected checksum
+type ChecksumError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
@@ -0,0 +1,87 @@
+package download
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// keptDirName is the subdirectory of the download directory holding the
+// artifacts kept after install. Being a directory, it is never touched by
+// CleanStale.
+const keptDirName = "kept"
+
+// SetKeepArtifacts makes KeepArtifact retain the last n installed artifacts
+// for post-mortem debugging. 0 disables keeping them.
+func (m *Manager) SetKeepArtifacts(n int) error {
+	m.keepArtifacts = n
+	if n <= 0 {
+		return nil
+	}
+	if err := os.MkdirAll(m.keptDir(), 0755); err != nil {
+		return fmt.Errorf("error creating kept artifacts directory: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) keptDir() string {
+	return filepath.Join(m.downloadDir, keptDirName)
+}
+
+// KeepArtifact moves the installed artifact at path to <download-dir>/kept
+// and removes the oldest kept artifacts beyond the configured count. Without
+// a count set, it removes the artifact like before.
+func (m *Manager) KeepArtifact(path string) error {
+	if m.keepArtifacts <= 0 {
+		return os.Remove(path)
+	}
+
+	kept := filepath.Join(m.keptDir(), filepath.Base(path))
+	if err := os.Rename(path, kept); err != nil {
+		return fmt.Errorf("error keeping artifact: %w", err)
+	}
+	// The modification time orders kept artifacts for rotation
+	now := time.Now()
+	os.Chtimes(kept, now, now)
+	logging.Infof("Kept installed artifact as %s", kept)
+	return m.rotateKept()
+}
+
+// rotateKept removes the oldest kept artifacts until at most the configured
+// count remain
+func (m *Manager) rotateKept() error {
+	entries, err := os.ReadDir(m.keptDir())
+	if err != nil {
+		return fmt.Errorf("error reading kept artifacts directory: %w", err)
+	}
+
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, info)
+		}
+	}
+	if len(files) <= m.keepArtifacts {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	for _, info := range files[:len(files)-m.keepArtifacts] {
+		path := filepath.Join(m.keptDir(), info.Name())
+		if err := os.Remove(path); err != nil {
+			logging.Warnf("Failed to remove kept artifact %s: %v", path, err)
+			continue
+		}
+		logging.Infof("Removed kept artifact %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeepArtifactRotates(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir)
+	if err := m.SetKeepArtifacts(2); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"v1.mender", "v2.mender", "v3.mender"}
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.KeepArtifact(path); err != nil {
+			t.Fatalf("KeepArtifact: %v", err)
+		}
+		// Backdate, so the order does not depend on the clock resolution
+		kept := time.Now().Add(time.Duration(i-len(names)) * time.Minute)
+		os.Chtimes(filepath.Join(m.keptDir(), name), kept, kept)
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left in the download directory", name)
+		}
+	}
+	path := filepath.Join(dir, "v4.mender")
+	if err := os.WriteFile(path, []byte("v4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.KeepArtifact(path); err != nil {
+		t.Fatalf("KeepArtifact: %v", err)
+	}
+
+	entries, err := os.ReadDir(m.keptDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, entry := range entries {
+		kept = append(kept, entry.Name())
+	}
+	if len(kept) != 2 || kept[0] != "v3.mender" || kept[1] != "v4.mender" {
+		t.Errorf("kept %v, want [v3.mender v4.mender]", kept)
+	}
+}
+
+func TestKeepArtifactDisabledRemoves(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(dir)
+	path := filepath.Join(dir, "v1.mender")
+	if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.KeepArtifact(path); err != nil {
+		t.Fatalf("KeepArtifact: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("artifact not removed: %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// manifestName is the file in the download directory that maps artifacts to
+// their partial downloads
+const manifestName = "downloads.manifest.json"
+
+// manifestMu serializes updates of manifests by managers sharing a directory
+var manifestMu sync.Mutex
+
+// manifestEntry records the partial download of one artifact
+type manifestEntry struct {
+	Filename string    `json:"filename"` // local name, without partialExt
+	Size     int64     `json:"size"`     // bytes downloaded when last recorded
+	ETag     string    `json:"etag,omitempty"`
+	Updated  time.Time `json:"updated"`
+}
+
+// SetArtifactID identifies the artifact of the following downloads across URL
+// changes, e.g. by its checksum. A partial download is then resumed even if
+// a refreshed signed URL yields a different filename. When empty, the URL
+// without its query identifies the artifact.
+func (m *Manager) SetArtifactID(id string) {
+	m.artifactID = id
+}
+
+// resumeKey returns the manifest key of the artifact downloaded from url
+func (m *Manager) resumeKey(url string) string {
+	id := m.artifactID
+	if id == "" {
+		id = url
+		if u, err := neturl.Parse(url); err == nil {
+			u.RawQuery, u.Fragment, u.User = "", "", nil
+			id = u.String()
+		}
+	} else if _, digest, err := ParseChecksum(id); err == nil {
+		id = strings.ToLower(digest)
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *Manager) manifestPath() string {
+	return filepath.Join(m.downloadDir, manifestName)
+}
+
+// loadManifest reads the manifest, returning an empty one if there is none
+func (m *Manager) loadManifest() map[string]manifestEntry {
+	manifest := make(map[string]manifestEntry)
+	data, err := os.ReadFile(m.manifestPath())
+	if err != nil {
+		return manifest
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		logging.Warnf("Ignoring unreadable download manifest: %v", err)
+		return make(map[string]manifestEntry)
+	}
+	return manifest
+}
+
+// updateManifest applies fn to the manifest and writes it back
+func (m *Manager) updateManifest(fn func(map[string]manifestEntry)) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	manifest := m.loadManifest()
+	fn(manifest)
+	if len(manifest) == 0 {
+		os.Remove(m.manifestPath())
+		return
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		logging.Warnf("Failed to encode download manifest: %v", err)
+		return
+	}
+	tmp := m.manifestPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		logging.Warnf("Failed to write download manifest: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, m.manifestPath()); err != nil {
+		logging.Warnf("Failed to write download manifest: %v", err)
+	}
+}
+
+// resumeFilename returns the local filename of an earlier partial download of
+// the artifact with key, or filename if there is none
+func (m *Manager) resumeFilename(key, filename string) string {
+	manifestMu.Lock()
+	entry, ok := m.loadManifest()[key]
+	manifestMu.Unlock()
+	if !ok || entry.Filename == filename || entry.Filename != filepath.Base(entry.Filename) {
+		return filename
+	}
+	if _, err := os.Stat(filepath.Join(m.partialDir(), entry.Filename+partialExt)); err != nil {
+		return filename
+	}
+	logging.Infof("Resuming earlier download of the same artifact as %s (%d bytes)", entry.Filename, entry.Size)
+	return entry.Filename
+}
+
+// recordPartial remembers filename as the partial download of the artifact
+// with key, along with what has been downloaded so far. It is recorded before
+// the download starts too, so a resume after a crash finds it.
+func (m *Manager) recordPartial(key, filename string) {
+	partialPath := filepath.Join(m.partialDir(), filename+partialExt)
+	entry := manifestEntry{Filename: filename, Updated: time.Now().UTC()}
+	if info, err := os.Stat(partialPath); err == nil {
+		entry.Size = info.Size()
+	}
+	if meta := loadPartialMeta(partialPath); meta != nil {
+		entry.ETag = meta.ETag
+	}
+	m.updateManifest(func(manifest map[string]manifestEntry) {
+		manifest[key] = entry
+	})
+}
+
+// forgetPartial removes the artifact with key from the manifest
+func (m *Manager) forgetPartial(key string) {
+	m.updateManifest(func(manifest map[string]manifestEntry) {
+		delete(manifest, key)
+	})
+}
+
+// SetResume controls whether a partial download left by an earlier run is
+// continued, the default, or discarded for a clean download. Retries within
+// one download still resume, their partial was written by this run.
+func (m *Manager) SetResume(enabled bool) {
+	m.resume = enabled
+}
+
+// discardEarlierPartial removes the partial download of the artifact with the
+// manifest key and its record, so it is downloaded from scratch
+func (m *Manager) discardEarlierPartial(key, filename string) error {
+	partialPath := filepath.Join(m.partialDir(), filename+partialExt)
+	if _, err := os.Stat(partialPath); err == nil {
+		logging.Infof("Discarding partial download %s, resuming is disabled", partialPath)
+	}
+	if err := discardPartial(partialPath); err != nil {
+		return err
+	}
+	m.forgetPartial(key)
+	return nil
+}
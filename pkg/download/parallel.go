@@ -0,0 +1,226 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// minParallelPartSize keeps artifacts too small to benefit on a single stream
+const minParallelPartSize = 4 * 1024 * 1024
+
+// SetParallelism makes downloads from servers that accept byte ranges use n
+// concurrent Range requests for disjoint parts of the file. Values below 2
+// keep a single stream.
+func (m *Manager) SetParallelism(n int) {
+	m.parallelism = n
+}
+
+// canDownloadParallel reports whether the HEAD response allows splitting the
+// download into m.parallelism Range requests
+func (m *Manager) canDownloadParallel(head *http.Response) bool {
+	if m.parallelism < 2 || head == nil || head.StatusCode != http.StatusOK {
+		return false
+	}
+	if !strings.Contains(strings.ToLower(head.Header.Get("Accept-Ranges")), "bytes") {
+		return false
+	}
+	if isEncoded(head.Header.Get("Content-Encoding")) {
+		return false
+	}
+	return head.ContentLength >= int64(m.parallelism)*minParallelPartSize
+}
+
+// downloadInParallel downloads the file described by the HEAD response with
+// downloadParallel and moves it to its final path, named like a single-stream
+// download would be
+func (m *Manager) downloadInParallel(ctx context.Context, client *http.Client, url, filename, partialPath, finalPath string, head *http.Response, digest hash.Hash) (string, error) {
+	size := head.ContentLength
+	logging.Infof("Downloading %d bytes with %d parallel range requests", size, m.parallelism)
+
+	finalName := dispositionFilename(head.Header.Get("Content-Disposition"))
+	if finalName != "" && finalName != filename {
+		logging.Debugf("Saving download as %s", finalName)
+		finalPath = filepath.Join(m.downloadDir, finalName)
+	}
+	if err := savePartialMeta(partialPath, partialMeta{TotalSize: size, Filename: finalName, Parallel: true}); err != nil {
+		logging.Warnf("Failed to write partial download metadata: %v", err)
+	}
+
+	start := time.Now()
+	if err := m.downloadParallel(ctx, client, url, partialPath, size); err != nil {
+		return "", err
+	}
+	speed := float64(size) / time.Since(start).Seconds()
+	logging.Infof("Download complete, total size: %d bytes, average speed: %.2f MB/s", size, megabytes(speed))
+
+	if digest != nil {
+		if err := seedDigest(digest, partialPath, size); err != nil {
+			return "", err
+		}
+	}
+	if err := moveFile(partialPath, finalPath); err != nil {
+		return "", fmt.Errorf("error renaming partial file: %w", err)
+	}
+	logging.Debugf("Renamed partial file %s to %s", partialPath, finalPath)
+	os.Remove(partialPath + checkpointExt)
+	recordFinalName(partialPath, filename, finalPath)
+	if err := saveValidators(finalPath, head.Header); err != nil {
+		logging.Warnf("Failed to write cache validators: %v", err)
+	}
+	return finalPath, nil
+}
+
+// downloadParallel fetches size bytes of url into path with m.parallelism
+// concurrent Range requests, each writing its part of the preallocated file
+func (m *Manager) downloadParallel(ctx context.Context, client *http.Client, url, path string, size int64) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error opening file: %w", err)
+	}
+	defer file.Close()
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("error preallocating file: %w", err)
+	}
+
+	// One failed part aborts the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := int64(m.parallelism)
+	partSize := (size + parts - 1) / parts
+	var downloaded atomic.Int64
+	errs := make(chan error, parts)
+	for i := int64(0); i < parts; i++ {
+		start := i * partSize
+		end := min(start+partSize, size) - 1
+		go func() {
+			err := m.downloadRange(ctx, client, url, file, start, end, &downloaded)
+			if err != nil {
+				cancel()
+			}
+			errs <- err
+		}()
+	}
+
+	// Report progress from one goroutine, the callback is not concurrency safe.
+	// The same goroutine aborts all parts when free space runs low.
+	stopProgress := make(chan struct{})
+	progressDone := make(chan struct{})
+	var spaceErr error
+	go func() {
+		defer close(progressDone)
+		ticker := time.NewTicker(progressCallbackInterval)
+		defer ticker.Stop()
+		lastSpaceCheck := time.Now()
+		for {
+			select {
+			case <-stopProgress:
+				return
+			case <-ticker.C:
+				if m.onProgress != nil {
+					m.onProgress(downloaded.Load(), size)
+				}
+				if time.Since(lastSpaceCheck) >= m.spaceWatchInterval {
+					lastSpaceCheck = time.Now()
+					if spaceErr = m.checkMinFree(); spaceErr != nil {
+						cancel()
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	var firstErr error
+	for i := int64(0); i < parts; i++ {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	close(stopProgress)
+	<-progressDone
+	if spaceErr != nil {
+		return spaceErr
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	if m.onProgress != nil {
+		m.onProgress(size, size)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("error syncing downloaded file: %w", err)
+	}
+	return nil
+}
+
+// downloadRange fetches bytes start to end (inclusive) of url into the same
+// offsets of file
+func (m *Manager) downloadRange(ctx context.Context, client *http.Client, url string, file *os.File, start, end int64, downloaded *atomic.Int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	m.setHeaders(req)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error downloading bytes %d-%d: %w", start, end, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("server answered range request for bytes %d-%d with status %d", start, end, resp.StatusCode)
+	}
+	if rangeStart, _, err := parseContentRange(resp.Header.Get("Content-Range")); err != nil || rangeStart != start {
+		return fmt.Errorf("server returned wrong range for bytes %d-%d: '%s'", start, end, resp.Header.Get("Content-Range"))
+	}
+
+	var body io.Reader = resp.Body
+	if m.stallTimeout > 0 {
+		watchdog := newStallReader(body, m.stallTimeout, cancel)
+		defer watchdog.stop()
+		body = watchdog
+	}
+	if m.rateLimit > 0 {
+		// Split the bandwidth cap between the parts
+		body = newRateLimitedReader(ctx, body, max(m.rateLimit/int64(m.parallelism), 1))
+	}
+
+	want := end - start + 1
+	written, err := io.Copy(&countingWriter{w: io.NewOffsetWriter(file, start), n: downloaded}, io.LimitReader(body, want))
+	if err != nil {
+		return fmt.Errorf("error reading bytes %d-%d: %w", start, end, err)
+	}
+	if written != want {
+		return fmt.Errorf("server closed range %d-%d after %d of %d bytes", start, end, written, want)
+	}
+	return nil
+}
+
+// countingWriter adds the bytes written through it to a shared counter
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,58 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDownloadParallelMatchesSingleStream(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789abcdef"), 2*minParallelPartSize/16+1)
+	var log requestLog
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			log.record(r)
+		}
+		http.ServeContent(w, r, "update.mender", modTime, bytes.NewReader(body))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		parallelism int
+		wantGets    int
+		wantRanges  bool
+	}{
+		{parallelism: 1, wantGets: 1},
+		{parallelism: 2, wantGets: 2, wantRanges: true},
+		// Parts would be smaller than minParallelPartSize
+		{parallelism: 4, wantGets: 1},
+	}
+	for _, tt := range tests {
+		log = requestLog{}
+		m := NewManager(t.TempDir())
+		m.SetParallelism(tt.parallelism)
+		path, digest, err := m.DownloadWithChecksum(context.Background(), server.URL+"/update.mender", "sha256")
+		if err != nil {
+			t.Fatalf("parallelism %d: DownloadWithChecksum: %v", tt.parallelism, err)
+		}
+		if got := readDownload(t, path); got != string(body) {
+			t.Errorf("parallelism %d: downloaded file differs from the served one", tt.parallelism)
+		}
+		if err := CompareDigest(digest, sha256Checksum(string(body))); err != nil {
+			t.Errorf("parallelism %d: %v", tt.parallelism, err)
+		}
+		requests := log.all()
+		if len(requests) != tt.wantGets {
+			t.Errorf("parallelism %d: %d GET requests, want %d", tt.parallelism, len(requests), tt.wantGets)
+		}
+		for _, r := range requests {
+			if ranged := r.Header.Get("Range") != ""; ranged != tt.wantRanges {
+				t.Errorf("parallelism %d: GET with Range %q", tt.parallelism, r.Header.Get("Range"))
+			}
+		}
+	}
+}
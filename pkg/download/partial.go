@@ -0,0 +1,137 @@
+package download
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// metaExt is appended to the partial file path to name its metadata sidecar
+const metaExt = ".meta"
+
+// partialMeta records what is known about the remote file a partial download
+// belongs to, so a resume can detect that the remote file has changed
+type partialMeta struct {
+	TotalSize int64  `json:"total_size"` // -1 if unknown
+	Filename  string `json:"filename,omitempty"`
+	ETag      string `json:"etag,omitempty"`
+
+	// ContentEncoding is set when the body was decompressed while writing. The
+	// partial then holds decoded bytes that a Range request cannot continue.
+	ContentEncoding string `json:"content_encoding,omitempty"`
+
+	// Parallel is set while a parallel download fills the preallocated partial out of order
+	Parallel bool `json:"parallel,omitempty"`
+
+	// Complete is set once the download finished and was saved as Filename,
+	// so a later download of the same URL finds it there
+	Complete bool `json:"complete,omitempty"`
+}
+
+// loadPartialMeta reads the metadata of a partial download, returning nil if there is none
+func loadPartialMeta(partialPath string) *partialMeta {
+	data, err := os.ReadFile(partialPath + metaExt)
+	if err != nil {
+		return nil
+	}
+	var meta partialMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil
+	}
+	return &meta
+}
+
+// savePartialMeta writes the metadata of a partial download
+func savePartialMeta(partialPath string, meta partialMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(partialPath+metaExt, data, 0644)
+}
+
+// recordFinalName keeps the metadata of a finished download that was saved
+// under another name than the partial, e.g. one from Content-Disposition, so
+// a later download of the same URL looks for it there. Otherwise the
+// metadata is removed.
+func recordFinalName(partialPath, filename, finalPath string) {
+	name := filepath.Base(finalPath)
+	if name == filename {
+		os.Remove(partialPath + metaExt)
+		return
+	}
+	if err := savePartialMeta(partialPath, partialMeta{TotalSize: -1, Filename: name, Complete: true}); err != nil {
+		logging.Warnf("Failed to record the name of the finished download: %v", err)
+	}
+}
+
+// completedPath returns where a finished download of filename is, following
+// a name recorded by recordFinalName
+func (m *Manager) completedPath(partialPath, filename string) string {
+	if meta := loadPartialMeta(partialPath); meta != nil && meta.Complete && meta.Filename != "" {
+		return filepath.Join(m.downloadDir, meta.Filename)
+	}
+	return filepath.Join(m.downloadDir, filename)
+}
+
+// discardPartial removes a partial download together with its sidecars
+func discardPartial(partialPath string) error {
+	if err := os.Remove(partialPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing partial file: %w", err)
+	}
+	os.Remove(partialPath + checkpointExt)
+	os.Remove(partialPath + metaExt)
+	return nil
+}
+
+// parseContentRange parses a "bytes start-end/total" Content-Range header.
+// The total is -1 if the server reported it as unknown ("*").
+func parseContentRange(header string) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported Content-Range '%s'", header)
+	}
+	rangePart, totalPart, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("malformed Content-Range '%s'", header)
+	}
+	startPart, _, ok := strings.Cut(rangePart, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("malformed Content-Range '%s'", header)
+	}
+
+	start, err := strconv.ParseInt(startPart, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed Content-Range '%s'", header)
+	}
+	total := int64(-1)
+	if totalPart != "*" {
+		total, err = strconv.ParseInt(totalPart, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("malformed Content-Range '%s'", header)
+		}
+	}
+	return start, total, nil
+}
+
+// checkResume verifies that a 206 response continues the partial download of
+// size offset. It returns a non-empty reason if the download must restart.
+func checkResume(resp *http.Response, offset int64, meta *partialMeta) string {
+	start, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return err.Error()
+	}
+	if start != offset {
+		return fmt.Sprintf("Server resumed at offset %d instead of %d", start, offset)
+	}
+	if meta != nil && meta.TotalSize >= 0 && total >= 0 && total != meta.TotalSize {
+		return fmt.Sprintf("Remote file changed size from %d to %d bytes", meta.TotalSize, total)
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// SetPreflight enables or disables the HEAD request sent before each
+// download. It is enabled by default; servers that mishandle HEAD can opt
+// out, at the cost of the free space check and parallel downloads.
+func (m *Manager) SetPreflight(enabled bool) {
+	m.preflight = enabled
+}
+
+// preflightHead issues a HEAD request for url before the transfer starts. It
+// fails if the server reports the artifact missing, so no GET is retried
+// against it. The response is returned for further inspection, or nil if
+// preflight is disabled or the server did not answer HEAD with 200.
+func (m *Manager) preflightHead(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	if !m.preflight {
+		return nil, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating HEAD request: %w", err)
+	}
+	m.setHeaders(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logging.Warnf("Preflight HEAD request failed, continuing without it: %v", err)
+		return nil, nil
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound, http.StatusGone:
+		return nil, fmt.Errorf("artifact not found on server (HEAD status %d)", resp.StatusCode)
+	default:
+		// e.g. presigned URLs that are only valid for GET
+		logging.Warnf("Server answered HEAD with status %d, continuing without preflight", resp.StatusCode)
+		return nil, nil
+	}
+}
+
+// resumeBlocker returns why the HEAD response rules out resuming a partial
+// download of size offset, or "" if it does not
+func resumeBlocker(head *http.Response, offset int64) string {
+	if strings.EqualFold(strings.TrimSpace(head.Header.Get("Accept-Ranges")), "none") {
+		return "Server does not accept range requests"
+	}
+	if head.ContentLength >= 0 && offset > head.ContentLength {
+		return fmt.Sprintf("Partial file of %d bytes is larger than the remote file of %d bytes", offset, head.ContentLength)
+	}
+	return ""
+}
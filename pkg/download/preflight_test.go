@@ -0,0 +1,100 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// headStatusServer answers HEAD with status and serves body on GET, counting
+// the GET requests
+func headStatusServer(t *testing.T, status int, body string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var gets atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(status)
+			return
+		}
+		gets.Add(1)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &gets
+}
+
+func TestPreflightMissingArtifact(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusGone} {
+		server, gets := headStatusServer(t, status, "release 2")
+		m := NewManager(t.TempDir())
+
+		start := time.Now()
+		_, err := m.Download(context.Background(), server.URL+"/update.mender")
+		if err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("HEAD %d: Download = %v, want artifact not found", status, err)
+		}
+		if n := gets.Load(); n != 0 {
+			t.Errorf("HEAD %d: sent %d GET requests, want none", status, n)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("HEAD %d: failed after %v, want without retrying", status, elapsed)
+		}
+	}
+}
+
+func TestPreflightMissingArtifactTriesNextMirror(t *testing.T) {
+	missing, missingGets := headStatusServer(t, http.StatusNotFound, "")
+	mirror, _ := rangeServer(t, "release 2")
+	m := NewManager(t.TempDir())
+
+	path, err := m.DownloadFromMirrors(context.Background(), []string{missing.URL + "/update.mender", mirror.URL + "/update.mender"})
+	if err != nil {
+		t.Fatalf("DownloadFromMirrors: %v", err)
+	}
+	if got := readDownload(t, path); got != "release 2" {
+		t.Errorf("downloaded %q from the second mirror", got)
+	}
+	if n := missingGets.Load(); n != 0 {
+		t.Errorf("sent %d GET requests to the mirror without the artifact", n)
+	}
+}
+
+func TestPreflightIgnoresOtherStatuses(t *testing.T) {
+	// e.g. a URL presigned for GET only
+	server, gets := headStatusServer(t, http.StatusForbidden, "release 2")
+	m := NewManager(t.TempDir())
+
+	path, err := m.Download(context.Background(), server.URL+"/update.mender")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got := readDownload(t, path); got != "release 2" || gets.Load() != 1 {
+		t.Errorf("downloaded %q with %d GET requests", got, gets.Load())
+	}
+}
+
+func TestPreflightDisabled(t *testing.T) {
+	var heads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads.Add(1)
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("release 2"))
+	}))
+	defer server.Close()
+	m := NewManager(t.TempDir())
+	m.SetPreflight(false)
+
+	if _, err := m.Download(context.Background(), server.URL+"/update.mender"); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if n := heads.Load(); n != 0 {
+		t.Errorf("sent %d HEAD requests with preflight disabled", n)
+	}
+}
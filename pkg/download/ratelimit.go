@@ -0,0 +1,68 @@
+package download
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// rateLimitedReader paces reads from r to at most rate bytes per second using
+// a token bucket that holds up to one second worth of bytes
+type rateLimitedReader struct {
+	ctx    context.Context
+	r      io.Reader
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimitedReader(ctx context.Context, r io.Reader, rate int64) *rateLimitedReader {
+	return &rateLimitedReader{
+		ctx:  ctx,
+		r:    r,
+		rate: rate,
+		last: time.Now(),
+	}
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	// Read at most a tenth of a second worth of data at a time so pacing stays smooth
+	chunk := l.rate / 10
+	if chunk < 1 {
+		chunk = 1
+	}
+	if int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+
+	n, err := l.r.Read(p)
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	// Wait until the bucket is no longer in debt
+	if l.tokens < 0 {
+		wait := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+		timer := time.NewTimer(wait)
+		select {
+		case <-l.ctx.Done():
+			timer.Stop()
+			if err == nil {
+				err = l.ctx.Err()
+			}
+		case <-timer.C:
+		}
+	}
+
+	return n, err
+}
+
+// SetRateLimit limits download bandwidth to rate bytes per second, 0 means unlimited
+func (m *Manager) SetRateLimit(rate int64) {
+	m.rateLimit = rate
+}
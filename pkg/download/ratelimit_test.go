@@ -0,0 +1,26 @@
+package download
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadRateLimit(t *testing.T) {
+	const rate = 40000
+	body := strings.Repeat("x", rate/2)
+	server, _ := artifactServer(t, body)
+
+	m := NewManager(t.TempDir())
+	m.SetRateLimit(rate)
+	start := time.Now()
+	if _, err := m.Download(context.Background(), server.URL+"/update.mender"); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	// The bucket starts empty, so half a second worth of data takes at least
+	// about half a second
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("download of %d bytes at %d bytes/s took %v, want at least 400ms", len(body), rate, elapsed)
+	}
+}
@@ -0,0 +1,195 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rangeServer serves body with http.ServeContent, which answers Range
+// requests and rejects ranges past the end with 416. It counts the GET
+// requests it answers.
+func rangeServer(t *testing.T, body string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var served atomic.Int32
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			served.Add(1)
+		}
+		http.ServeContent(w, r, "update.mender", modTime, strings.NewReader(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &served
+}
+
+// writePartial leaves a partial download of filename in dir
+func writePartial(t *testing.T, dir, filename, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, filename+partialExt)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readDownload returns the contents of a downloaded file
+func readDownload(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestResumeLargerPartialRestarts(t *testing.T) {
+	const body = "release 2"
+	for _, preflight := range []bool{true, false} {
+		server, _ := rangeServer(t, body)
+		dir := t.TempDir()
+		writePartial(t, dir, "update.mender", "a partial longer than the remote file")
+
+		m := NewManager(dir)
+		// Without the HEAD request the server's 416 reveals the size mismatch
+		m.SetPreflight(preflight)
+		path, err := m.Download(context.Background(), server.URL+"/update.mender")
+		if err != nil {
+			t.Fatalf("preflight %v: Download: %v", preflight, err)
+		}
+		if got := readDownload(t, path); got != body {
+			t.Errorf("preflight %v: downloaded %q, want %q", preflight, got, body)
+		}
+	}
+}
+
+func TestResumeDetectsChangedSize(t *testing.T) {
+	const body = "release 2, rebuilt"
+	server, _ := rangeServer(t, body)
+	dir := t.TempDir()
+	partial := writePartial(t, dir, "update.mender", "RELEASE")
+	// The partial belongs to a remote file of another size
+	if err := savePartialMeta(partial, partialMeta{TotalSize: 12}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(dir)
+	m.SetPreflight(false)
+	path, err := m.Download(context.Background(), server.URL+"/update.mender")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got := readDownload(t, path); got != body {
+		t.Errorf("downloaded %q, want %q", got, body)
+	}
+}
+
+func TestResumeAppendsToPartial(t *testing.T) {
+	const body = "release 2, rebuilt"
+	server, _ := rangeServer(t, body)
+	dir := t.TempDir()
+	partial := writePartial(t, dir, "update.mender", "RELEASE")
+	if err := savePartialMeta(partial, partialMeta{TotalSize: int64(len(body))}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(dir)
+	path, err := m.Download(context.Background(), server.URL+"/update.mender")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	// Only the missing tail was fetched
+	if got, want := readDownload(t, path), "RELEASE"+body[7:]; got != want {
+		t.Errorf("downloaded %q, want %q", got, want)
+	}
+}
+
+func TestResumeFollowsRedirects(t *testing.T) {
+	const body = "release 2, rebuilt"
+	var storageLog requestLog
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storageLog.record(r)
+		http.ServeContent(w, r, "update.mender", modTime, strings.NewReader(body))
+	}))
+	defer storage.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old.mender" {
+			http.Redirect(w, r, "/update.mender", http.StatusFound)
+			return
+		}
+		// Credentials are re-applied after a redirect on the same host
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, storage.URL+"/bucket/update.mender?X-Amz-Signature=abc", http.StatusFound)
+	}))
+	defer origin.Close()
+	dir := t.TempDir()
+	partial := writePartial(t, dir, "old.mender", "RELEASE")
+	if err := savePartialMeta(partial, partialMeta{TotalSize: int64(len(body))}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(dir)
+	m.SetAuth("secret", "")
+	path, err := m.Download(context.Background(), origin.URL+"/old.mender")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got, want := readDownload(t, path), "RELEASE"+body[7:]; got != want {
+		t.Errorf("downloaded %q, want %q", got, want)
+	}
+	for _, r := range storageLog.all() {
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("storage got Authorization %q", auth)
+		}
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "bytes=7-" {
+			t.Errorf("storage got Range %q, want bytes=7-", r.Header.Get("Range"))
+		}
+	}
+}
+
+func TestDownloadShorterThanContentLength(t *testing.T) {
+	const body = "release 2, rebuilt"
+	var lying atomic.Bool
+	lying.Store(true)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lying.Load() && r.Method == http.MethodGet {
+			// Advertise the whole file but stop after the first bytes
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write([]byte(body[:7]))
+			return
+		}
+		http.ServeContent(w, r, "update.mender", modTime, strings.NewReader(body))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	m := NewManager(dir)
+	if path, err := m.Download(context.Background(), server.URL+"/update.mender"); err == nil {
+		t.Fatalf("Download of a truncated body = %s, want an error", path)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "update.mender")); err == nil {
+		t.Error("truncated download was saved as complete")
+	}
+
+	// The partial is kept and resumed once the server behaves
+	lying.Store(false)
+	path, err := m.Download(context.Background(), server.URL+"/update.mender")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got := readDownload(t, path); got != body {
+		t.Errorf("downloaded %q, want %q", got, body)
+	}
+}
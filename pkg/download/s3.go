@@ -0,0 +1,169 @@
+package download
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3URLPrefix marks artifacts addressed by bucket and key, s3://bucket/key
+const s3URLPrefix = "s3://"
+
+// emptyPayloadHash is the SHA-256 of the empty body of GET and HEAD requests
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Credentials sign requests to S3 with AWS Signature Version 4
+type s3Credentials struct {
+	accessKey string
+	secretKey string
+	region    string
+}
+
+// SetS3Credentials makes requests to S3 hosts, including range and HEAD
+// requests, signed with AWS Signature Version 4, so artifacts in a private
+// bucket need no presigned URL. Requests to other hosts are not signed. The
+// region also selects the endpoint of s3:// URLs. An empty access key
+// disables signing.
+func (m *Manager) SetS3Credentials(accessKey, secretKey, region string) {
+	m.s3Region = region
+	if accessKey == "" {
+		m.s3 = nil
+		return
+	}
+	m.s3 = &s3Credentials{accessKey: accessKey, secretKey: secretKey, region: region}
+}
+
+// IsS3URL reports whether url addresses an object as s3://bucket/key
+func IsS3URL(url string) bool {
+	return strings.HasPrefix(url, s3URLPrefix)
+}
+
+// s3HTTPURL turns s3://bucket/key into the virtual-hosted-style HTTPS URL of
+// the object in region, or in the global endpoint if region is empty
+func s3HTTPURL(url, region string) (string, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(url, s3URLPrefix), "/")
+	if bucket == "" || key == "" {
+		return "", fmt.Errorf("invalid S3 URL '%s', expected s3://bucket/key", url)
+	}
+	host := bucket + ".s3.amazonaws.com"
+	if region != "" {
+		host = bucket + ".s3." + region + ".amazonaws.com"
+	}
+	u := neturl.URL{Scheme: "https", Host: host, Path: "/" + key}
+	return u.String(), nil
+}
+
+// isS3Host reports whether host is an AWS S3 endpoint, in any of the
+// s3.amazonaws.com, s3.<region>.amazonaws.com or s3-<region>.amazonaws.com
+// forms, with or without the bucket as a subdomain
+func isS3Host(host string) bool {
+	if h, _, ok := strings.Cut(host, ":"); ok {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, ".amazonaws.com") {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, ".amazonaws.com"), ".") {
+		if label == "s3" || strings.HasPrefix(label, "s3-") {
+			return true
+		}
+	}
+	return false
+}
+
+// sign sets the SigV4 Authorization header of req, which has an empty body
+func (c *s3Credentials) sign(req *http.Request) {
+	c.signAt(req, time.Now())
+}
+
+// signAt signs req as if sent at now
+func (c *s3Credentials) signAt(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	// Send the path encoded exactly as it is signed
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	req.URL.RawPath = s3Escape(path, false)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.RawPath,
+		canonicalQuery(req.URL.Query()),
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + emptyPayloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, then value, as
+// SigV4 requires
+func canonicalQuery(query neturl.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var params []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// s3Escape percent-encodes everything but the RFC 3986 unreserved characters,
+// and slashes unless encodeSlash is set
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// ErrInsufficientSpace is returned when the download directory cannot hold the artifact
+var ErrInsufficientSpace = errors.New("insufficient space in download directory")
+
+// statfsFreeSpace returns the bytes available to unprivileged users on the
+// filesystem backing path
+func statfsFreeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("error checking free space of %s: %w", path, err)
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// defaultSpaceWatchInterval is how often free space is checked while downloading
+const defaultSpaceWatchInterval = 5 * time.Second
+
+// SetMinFreeSpace makes downloads abort with ErrInsufficientSpace when the
+// free space where the partial download is written drops below floor bytes
+// while downloading, e.g. because something else fills the filesystem. The
+// partial is kept so the download can resume later. 0 disables the check.
+func (m *Manager) SetMinFreeSpace(floor int64) {
+	m.minFree = floor
+}
+
+// checkMinFree fails with ErrInsufficientSpace if the free space for partial
+// downloads is below the floor set with SetMinFreeSpace
+func (m *Manager) checkMinFree() error {
+	if m.minFree <= 0 {
+		return nil
+	}
+	available, err := m.freeSpace(m.partialDir())
+	if err != nil {
+		logging.Debugf("Skipping free space check: %v", err)
+		return nil
+	}
+	if available < m.minFree {
+		return fmt.Errorf("%w: %d bytes left in %s, below the floor of %d bytes", ErrInsufficientSpace, available, m.partialDir(), m.minFree)
+	}
+	return nil
+}
+
+// SetSpaceMargin sets the number of bytes that must remain free in the
+// download directory in addition to the artifact itself
+func (m *Manager) SetSpaceMargin(margin int64) {
+	m.spaceMargin = margin
+}
+
+// checkSpace fails with ErrInsufficientSpace if the remaining bytes of the
+// artifact described by the HEAD response plus the safety margin don't fit
+// into the download directory. The check is skipped without a HEAD response
+// or if the server doesn't advertise a length.
+func (m *Manager) checkSpace(head *http.Response, offset int64) error {
+	if head == nil || head.ContentLength < 0 {
+		logging.Infof("Skipping free space check, server did not advertise a length")
+		return nil
+	}
+
+	if err := m.checkDirSpace(m.partialDir(), head.ContentLength-offset); err != nil {
+		return err
+	}
+	// The completed file is copied to a download directory on another filesystem
+	if m.stagingDir != "" && !sameFilesystem(m.stagingDir, m.downloadDir) {
+		return m.checkDirSpace(m.downloadDir, head.ContentLength)
+	}
+	return nil
+}
+
+// checkDirSpace fails with ErrInsufficientSpace if size bytes plus the safety
+// margin don't fit into dir
+func (m *Manager) checkDirSpace(dir string, size int64) error {
+	available, err := m.freeSpace(dir)
+	if err != nil {
+		logging.Infof("Skipping free space check: %v", err)
+		return nil
+	}
+
+	needed := size + m.spaceMargin
+	if available < needed {
+		return fmt.Errorf("%w in %s: need %d bytes (including %d byte margin), %d available", ErrInsufficientSpace, dir, needed, m.spaceMargin, available)
+	}
+
+	logging.Debugf("Free space check passed for %s: need %d bytes, %d available", dir, needed, available)
+	return nil
+}
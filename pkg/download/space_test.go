@@ -0,0 +1,66 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDownloadChecksSpaceBeforeTransfer(t *testing.T) {
+	server, served := rangeServer(t, strings.Repeat("x", 1000))
+	m := NewManager(t.TempDir())
+	m.freeSpace = func(path string) (int64, error) { return 1100, nil }
+	m.SetSpaceMargin(200)
+
+	_, err := m.Download(context.Background(), server.URL+"/update.mender")
+	if !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("Download = %v, want ErrInsufficientSpace", err)
+	}
+	if n := served.Load(); n != 0 {
+		t.Errorf("sent %d GET requests, want none", n)
+	}
+
+	m.SetSpaceMargin(100)
+	if _, err := m.Download(context.Background(), server.URL+"/update.mender"); err != nil {
+		t.Errorf("Download with enough space: %v", err)
+	}
+}
+
+func TestDownloadAbortsBelowMinFreeSpace(t *testing.T) {
+	const half = "first half "
+	var free atomic.Int64
+	free.Store(1 << 30)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "22")
+		if r.Method == http.MethodHead {
+			return
+		}
+		// Something else fills the filesystem while downloading
+		free.Store(10)
+		w.Write([]byte(half))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	m := NewManager(dir)
+	m.freeSpace = func(path string) (int64, error) { return free.Load(), nil }
+	m.spaceWatchInterval = 0
+	m.SetMinFreeSpace(1000)
+
+	_, err := m.Download(context.Background(), server.URL+"/update.mender")
+	if !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("Download = %v, want ErrInsufficientSpace", err)
+	}
+	partial, err := os.ReadFile(filepath.Join(dir, "update.mender"+partialExt))
+	if err != nil || string(partial) != half {
+		t.Errorf("partial = %q, %v, want the bytes received kept for a resume", partial, err)
+	}
+}
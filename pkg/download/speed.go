@@ -0,0 +1,58 @@
+package download
+
+import "time"
+
+// defaultProgressInterval is how often download progress is logged unless configured
+const defaultProgressInterval = 5 * time.Second
+
+// speedAlpha is the weight of the latest interval in the smoothed speed
+const speedAlpha = 0.3
+
+// SetProgressInterval sets how often download progress and speed are logged
+func (m *Manager) SetProgressInterval(interval time.Duration) {
+	m.progressInterval = interval
+}
+
+// speedMeter tracks download throughput, both smoothed over recent intervals
+// and averaged over the current attempt. Bytes from an earlier attempt are
+// not counted, so a resumed download does not report inflated speeds.
+type speedMeter struct {
+	start      time.Time
+	startBytes int64
+	last       time.Time
+	lastBytes  int64
+	smoothed   float64 // bytes per second, exponentially weighted
+}
+
+func newSpeedMeter(now time.Time, offset int64) *speedMeter {
+	return &speedMeter{start: now, startBytes: offset, last: now, lastBytes: offset, smoothed: -1}
+}
+
+// update records that total bytes have been received by now and returns
+// the smoothed and average speed in bytes per second
+func (s *speedMeter) update(now time.Time, total int64) (current, average float64) {
+	if elapsed := now.Sub(s.last).Seconds(); elapsed > 0 {
+		sample := float64(total-s.lastBytes) / elapsed
+		if s.smoothed < 0 {
+			s.smoothed = sample
+		} else {
+			s.smoothed = speedAlpha*sample + (1-speedAlpha)*s.smoothed
+		}
+		s.last, s.lastBytes = now, total
+	}
+	return max(s.smoothed, 0), s.average(now, total)
+}
+
+// average returns the average speed of the current attempt in bytes per second
+func (s *speedMeter) average(now time.Time, total int64) float64 {
+	elapsed := now.Sub(s.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(total-s.startBytes) / elapsed
+}
+
+// megabytes converts bytes per second to MB/s for logging
+func megabytes(bytesPerSecond float64) float64 {
+	return bytesPerSecond / 1024 / 1024
+}
@@ -0,0 +1,94 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// SetStagingDir makes partial downloads and their sidecars go to dir instead
+// of the download directory. Completed files are moved to the download
+// directory, copied if dir is on another filesystem. An empty dir keeps
+// partials in the download directory.
+func (m *Manager) SetStagingDir(dir string) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating staging directory: %w", err)
+		}
+	}
+	m.stagingDir = dir
+	return nil
+}
+
+// partialDir returns the directory partial downloads are written to
+func (m *Manager) partialDir() string {
+	if m.stagingDir != "" {
+		return m.stagingDir
+	}
+	return m.downloadDir
+}
+
+// moveFile renames src to dst. Across filesystems, where a rename is not
+// possible, src is copied next to dst, synced and renamed into place, so dst
+// never appears incomplete.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	logging.Infof("Copying %s to %s on another filesystem", src, dst)
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies src next to dst, syncs the copy and renames it into place
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + partialExt
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("error copying to %s: %w", filepath.Dir(dst), err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// sameFilesystem reports whether the paths a and b are on the same
+// filesystem, assuming so if either cannot be checked
+func sameFilesystem(a, b string) bool {
+	var statA, statB syscall.Stat_t
+	if syscall.Stat(a, &statA) != nil || syscall.Stat(b, &statB) != nil {
+		return true
+	}
+	return statA.Dev == statB.Dev
+}
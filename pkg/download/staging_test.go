@@ -0,0 +1,74 @@
+package download
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+// otherFilesystemDir returns a temporary directory on another filesystem than
+// dir, skipping the test if there is none
+func otherFilesystemDir(t *testing.T, dir string) string {
+	t.Helper()
+	for _, root := range []string{"/dev/shm", os.TempDir()} {
+		other, err := os.MkdirTemp(root, "smut-staging")
+		if err != nil {
+			continue
+		}
+		t.Cleanup(func() { os.RemoveAll(other) })
+		if !sameFilesystem(other, dir) {
+			return other
+		}
+	}
+	t.Skip("no second filesystem to stage downloads on")
+	return ""
+}
+
+func TestDownloadStagedOnAnotherFilesystem(t *testing.T) {
+	const body = "release 2"
+	server, _ := rangeServer(t, body)
+	dir := t.TempDir()
+	staging := otherFilesystemDir(t, dir)
+
+	m := NewManager(dir)
+	if err := m.SetStagingDir(staging); err != nil {
+		t.Fatal(err)
+	}
+	path, err := m.Download(context.Background(), server.URL+"/update.mender")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got := readDownload(t, path); got != body {
+		t.Errorf("downloaded %q, want %q", got, body)
+	}
+	if want := dir + "/update.mender"; path != want {
+		t.Errorf("Download = %s, want %s", path, want)
+	}
+	if entries, _ := os.ReadDir(staging); len(entries) != 0 {
+		t.Errorf("left %d files in the staging directory after the copy", len(entries))
+	}
+	if _, err := os.Stat(path + partialExt); !os.IsNotExist(err) {
+		t.Errorf("temporary copy left behind: %v", err)
+	}
+}
+
+func TestMoveFileAcrossFilesystems(t *testing.T) {
+	dir := t.TempDir()
+	src := otherFilesystemDir(t, dir) + "/a.mender"
+	if err := os.WriteFile(src, []byte("artifact"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dst := dir + "/a.mender"
+	if err := moveFile(src, dst); err != nil {
+		t.Fatalf("moveFile: %v", err)
+	}
+	if got := readDownload(t, dst); got != "artifact" {
+		t.Errorf("moved file holds %q", got)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source left behind: %v", err)
+	}
+	if _, err := os.Stat(dst + partialExt); !os.IsNotExist(err) {
+		t.Errorf("temporary copy left behind: %v", err)
+	}
+}
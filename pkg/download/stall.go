@@ -0,0 +1,56 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStalled is returned when no data arrives within the stall timeout
+var ErrStalled = errors.New("download stalled")
+
+// stallReader cancels a download when reads from r make no progress for the
+// given timeout. The timer is reset on every read that returns data, so a slow
+// but moving transfer is never aborted.
+type stallReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+func newStallReader(r io.Reader, timeout time.Duration, cancel context.CancelFunc) *stallReader {
+	s := &stallReader{
+		r:       r,
+		timeout: timeout,
+	}
+	s.timer = time.AfterFunc(timeout, func() {
+		s.stalled.Store(true)
+		cancel()
+	})
+	return s
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.timer.Reset(s.timeout)
+	}
+	if err != nil && err != io.EOF && s.stalled.Load() {
+		err = ErrStalled
+	}
+	return n, err
+}
+
+// stop disarms the watchdog
+func (s *stallReader) stop() {
+	s.timer.Stop()
+}
+
+// SetStallTimeout aborts downloads that receive no data for the given
+// duration, 0 disables the watchdog
+func (m *Manager) SetStallTimeout(timeout time.Duration) {
+	m.stallTimeout = timeout
+}
@@ -0,0 +1,35 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDownloadStall(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		if r.Method != http.MethodGet {
+			return
+		}
+		w.Write([]byte("release 2"))
+		w.(http.Flusher).Flush()
+		// Keep the connection open without sending the rest
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	m := NewManager(t.TempDir())
+	m.SetStallTimeout(100 * time.Millisecond)
+	start := time.Now()
+	_, err := m.Download(context.Background(), server.URL+"/update.mender")
+	if !errors.Is(err, ErrStalled) {
+		t.Fatalf("Download = %v, want %v", err, ErrStalled)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stalled download was aborted after %v", elapsed)
+	}
+}
@@ -0,0 +1,56 @@
+package download
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+// cacheExt is appended to a downloaded file's path to name the sidecar with
+// its HTTP cache validators
+const cacheExt = ".cache"
+
+// validators are the HTTP cache validators a downloaded file was served with,
+// used to revalidate the local copy with a conditional request
+type validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// loadValidators reads the validators of a downloaded file, returning nil if there are none
+func loadValidators(path string) *validators {
+	data, err := os.ReadFile(path + cacheExt)
+	if err != nil {
+		return nil
+	}
+	var v validators
+	if err := json.Unmarshal(data, &v); err != nil || (v.ETag == "" && v.LastModified == "") {
+		return nil
+	}
+	return &v
+}
+
+// saveValidators records the validators from a response next to the
+// downloaded file, or removes stale ones if the server sent none
+func saveValidators(path string, header http.Header) error {
+	v := validators{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified")}
+	if v.ETag == "" && v.LastModified == "" {
+		os.Remove(path + cacheExt)
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+cacheExt, data, 0644)
+}
+
+// setConditional makes req conditional on the local copy being outdated
+func (v *validators) setConditional(req *http.Request) {
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+}
@@ -0,0 +1,57 @@
+package download
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// digestRecord is a digest computed for a file, valid while the file keeps
+// its size and modification time
+type digestRecord struct {
+	size      int64
+	modTime   time.Time
+	algorithm string
+	digest    string
+}
+
+// digestCache remembers the digests of files hashed by VerifyChecksum, so the
+// same unchanged file, e.g. a file:// artifact pushed repeatedly, is not read
+// from flash again
+type digestCache struct {
+	mu      sync.Mutex
+	records map[string]digestRecord
+}
+
+// digest returns the digest of the file at path with algorithm, computing it
+// only if the file's size or modification time changed since it was last
+// computed
+func (c *digestCache) digest(path, algorithm string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		// FileDigest reports the error in its usual form
+		return FileDigest(path, algorithm)
+	}
+
+	c.mu.Lock()
+	record, ok := c.records[path]
+	c.mu.Unlock()
+	if ok && record.algorithm == algorithm && record.size == info.Size() && record.modTime.Equal(info.ModTime()) {
+		logging.Debugf("Using remembered %s digest of unchanged file %s", algorithm, path)
+		return record.digest, nil
+	}
+
+	digest, err := FileDigest(path, algorithm)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	if c.records == nil {
+		c.records = make(map[string]digestRecord)
+	}
+	c.records[path] = digestRecord{size: info.Size(), modTime: info.ModTime(), algorithm: algorithm, digest: digest}
+	c.mu.Unlock()
+	return digest, nil
+}
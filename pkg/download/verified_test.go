@@ -0,0 +1,40 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifyChecksumRemembersDigest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.mender")
+	if err := os.WriteFile(path, []byte("release 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(path, modTime, modTime)
+
+	m := NewManager(t.TempDir())
+	if err := m.VerifyChecksum(path, sha256Checksum("release 1")); err != nil {
+		t.Fatalf("VerifyChecksum: %v", err)
+	}
+
+	// Same size and modification time, so the file is not read again
+	if err := os.WriteFile(path, []byte("release 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, modTime, modTime)
+	if err := m.VerifyChecksum(path, sha256Checksum("release 1")); err != nil {
+		t.Errorf("VerifyChecksum of unchanged file: %v", err)
+	}
+
+	// A new modification time means the file is hashed again
+	os.Chtimes(path, time.Now(), time.Now())
+	if err := m.VerifyChecksum(path, sha256Checksum("release 1")); err == nil {
+		t.Error("VerifyChecksum used the digest of a changed file")
+	}
+	if err := m.VerifyChecksum(path, sha256Checksum("release 2")); err != nil {
+		t.Errorf("VerifyChecksum of changed file: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Package flock takes exclusive advisory locks on files, so smut and other
+// processes on the device don't run conflicting operations at the same time.
+package flock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// PollInterval is how often a contended lock is retried
+const PollInterval = 500 * time.Millisecond
+
+// ErrContended is returned when the lock is still held by another process
+// once the timeout has passed
+var ErrContended = errors.New("lock is held by another process")
+
+// Lock is an exclusive flock held on a file. The kernel releases it if the
+// process dies.
+type Lock struct {
+	file *os.File
+}
+
+// Acquire takes an exclusive flock on path, creating the file if needed, and
+// retries every PollInterval until timeout or until ctx is done. onWait, if
+// not nil, is called once if the lock is held by another process.
+func Acquire(ctx context.Context, path string, timeout time.Duration, onWait func()) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file %s: %w", path, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	waited := false
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return &Lock{file: file}, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, fmt.Errorf("error locking %s: %w", path, err)
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, fmt.Errorf("%w: %s (waited %v)", ErrContended, path, timeout)
+		}
+		if !waited && onWait != nil {
+			onWait()
+		}
+		waited = true
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, fmt.Errorf("error waiting for lock %s: %w", path, ctx.Err())
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// File returns the locked file, e.g. to record the holder in it
+func (l *Lock) File() *os.File {
+	return l.file
+}
+
+// Release unlocks and closes the file
+func (l *Lock) Release() {
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+}
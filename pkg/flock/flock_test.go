@@ -0,0 +1,64 @@
+package flock
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireContended(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	held, err := Acquire(context.Background(), path, 0, nil)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	waits := 0
+	_, err = Acquire(context.Background(), path, 10*time.Millisecond, func() { waits++ })
+	if !errors.Is(err, ErrContended) {
+		t.Fatalf("Acquire of a held lock = %v, want ErrContended", err)
+	}
+	if waits != 1 {
+		t.Errorf("onWait called %d times, want 1", waits)
+	}
+
+	held.Release()
+	lock, err := Acquire(context.Background(), path, 0, nil)
+	if err != nil {
+		t.Fatalf("Acquire after Release: %v", err)
+	}
+	lock.Release()
+}
+
+func TestAcquireCanceled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	held, err := Acquire(context.Background(), path, 0, nil)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	defer held.Release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Acquire(ctx, path, time.Minute, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Acquire with a canceled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestAcquireWaitsForRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	held, err := Acquire(context.Background(), path, 0, nil)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	time.AfterFunc(PollInterval/2, held.Release)
+
+	lock, err := Acquire(context.Background(), path, 5*time.Second, nil)
+	if err != nil {
+		t.Fatalf("Acquire while the holder releases: %v", err)
+	}
+	lock.Release()
+}
@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// level is the minimum level that is logged
+var level = new(slog.LevelVar)
+
+// ParseLevel parses a level name (debug, info, warn or error)
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level '%s', must be 'debug', 'info', 'warn' or 'error'", name)
+}
+
+// Setup installs a structured logger on stderr that drops records below the
+// given level. Calls to the standard log package are routed through it at
+// info level, so they are filtered too.
+func Setup(levelName string) error {
+	return setup(os.Stderr, levelName)
+}
+
+// setup installs the logger of Setup writing to w
+func setup(w io.Writer, levelName string) error {
+	l, err := ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+
+	// Source locations are only recorded for the standard log package when it asks for them
+	log.SetFlags(log.Lshortfile)
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       level,
+		ReplaceAttr: shortSource,
+	})))
+	return nil
+}
+
+// shortSource trims the source attribute to file:line
+func shortSource(groups []string, a slog.Attr) slog.Attr {
+	if src, ok := a.Value.Any().(*slog.Source); ok && a.Key == slog.SourceKey {
+		a.Value = slog.StringValue(fmt.Sprintf("%s:%d", filepath.Base(src.File), src.Line))
+	}
+	return a
+}
+
+// logf formats and logs a message at the given level, attributed to the
+// caller of the exported function
+func logf(l slog.Level, format string, args ...interface{}) {
+	logger := slog.Default()
+	if !logger.Enabled(context.Background(), l) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), l, fmt.Sprintf(format, args...), pcs[0])
+	logger.Handler().Handle(context.Background(), r)
+}
+
+// Debugf logs at debug level, for chatty details such as progress
+func Debugf(format string, args ...interface{}) {
+	logf(slog.LevelDebug, format, args...)
+}
+
+// Infof logs at info level
+func Infof(format string, args ...interface{}) {
+	logf(slog.LevelInfo, format, args...)
+}
+
+// Warnf logs at warn level
+func Warnf(format string, args ...interface{}) {
+	logf(slog.LevelWarn, format, args...)
+}
+
+// Errorf logs at error level
+func Errorf(format string, args ...interface{}) {
+	logf(slog.LevelError, format, args...)
+}
+
+// Fatalf logs at error level, which is never filtered, and exits
+func Fatalf(format string, args ...interface{}) {
+	logf(slog.LevelError, format, args...)
+	os.Exit(1)
+}
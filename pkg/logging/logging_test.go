@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSetupFiltersBelowLevel(t *testing.T) {
+	previous, flags := slog.Default(), log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetFlags(flags)
+	})
+
+	var out bytes.Buffer
+	if err := setup(&out, "warn"); err != nil {
+		t.Fatalf("setup() error = %v", err)
+	}
+	Debugf("debug message")
+	Infof("info message")
+	log.Printf("standard log message")
+	Warnf("warn message")
+	Errorf("error message")
+
+	logged := out.String()
+	for _, hidden := range []string{"debug message", "info message", "standard log message"} {
+		if strings.Contains(logged, hidden) {
+			t.Errorf("%q was logged at level warn:\n%s", hidden, logged)
+		}
+	}
+	for _, shown := range []string{"warn message", "error message"} {
+		if !strings.Contains(logged, shown) {
+			t.Errorf("%q was not logged at level warn:\n%s", shown, logged)
+		}
+	}
+	// Records are attributed to the caller, not to this package's helpers
+	if !strings.Contains(logged, "source=logging_test.go:") {
+		t.Errorf("records lack the caller's source:\n%s", logged)
+	}
+
+	if err := setup(&out, "verbose"); err == nil {
+		t.Error("setup() accepted an invalid level")
+	}
+}
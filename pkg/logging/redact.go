@@ -0,0 +1,17 @@
+package logging
+
+import "net/url"
+
+// RedactURL strips the credentials, query and fragment from a URL so signed
+// tokens and passwords don't leak into logs or telemetry. A URL that cannot
+// be parsed is dropped entirely.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
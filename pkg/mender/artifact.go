@@ -0,0 +1,109 @@
+package mender
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ArtifactInfo holds the metadata of a mender artifact read from its header
+type ArtifactInfo struct {
+	Name         string
+	DeviceTypes  []string
+	PayloadTypes []string
+}
+
+// headerInfo mirrors the header-info file of a version 3 mender artifact
+type headerInfo struct {
+	Payloads []struct {
+		Type string `json:"type"`
+	} `json:"payloads"`
+	ArtifactProvides struct {
+		ArtifactName string `json:"artifact_name"`
+	} `json:"artifact_provides"`
+	ArtifactDepends struct {
+		DeviceType []string `json:"device_type"`
+	} `json:"artifact_depends"`
+}
+
+// ReadArtifactInfo parses the header of the mender artifact at filePath.
+// Only gzip-compressed headers (the mender-artifact default) are supported.
+func (c *Client) ReadArtifactInfo(filePath string) (*ArtifactInfo, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening artifact: %w", err)
+	}
+	defer file.Close()
+
+	outer := tar.NewReader(file)
+	for {
+		hdr, err := outer.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("artifact has no header")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading artifact: %w", err)
+		}
+
+		switch {
+		case hdr.Name == "header.tar.gz":
+			return readHeader(outer)
+		case strings.HasPrefix(hdr.Name, "header.tar"):
+			return nil, fmt.Errorf("unsupported artifact header compression: %s", hdr.Name)
+		case strings.HasPrefix(hdr.Name, "data/"):
+			// The header always precedes the payload data
+			return nil, fmt.Errorf("artifact has no header")
+		}
+	}
+}
+
+// readHeader extracts the artifact info from a gzip-compressed header tarball
+func readHeader(r io.Reader) (*ArtifactInfo, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing artifact header: %w", err)
+	}
+	defer gz.Close()
+
+	header := tar.NewReader(gz)
+	for {
+		hdr, err := header.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("artifact header has no header-info")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading artifact header: %w", err)
+		}
+		if hdr.Name != "header-info" {
+			continue
+		}
+
+		var hi headerInfo
+		if err := json.NewDecoder(header).Decode(&hi); err != nil {
+			return nil, fmt.Errorf("error parsing header-info: %w", err)
+		}
+
+		info := &ArtifactInfo{
+			Name:        hi.ArtifactProvides.ArtifactName,
+			DeviceTypes: hi.ArtifactDepends.DeviceType,
+		}
+		for _, payload := range hi.Payloads {
+			info.PayloadTypes = append(info.PayloadTypes, payload.Type)
+		}
+		return info, nil
+	}
+}
+
+// SupportsDevice reports whether the artifact may be installed on deviceType
+func (a *ArtifactInfo) SupportsDevice(deviceType string) bool {
+	for _, t := range a.DeviceTypes {
+		if t == deviceType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package mender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/librescoot/smut/pkg/flock"
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// ErrLockContended is returned when the mender lock could not be acquired in time
+var ErrLockContended = errors.New("mender lock is held by another process")
+
+// acquireLock takes an exclusive flock on path, retrying until timeout or
+// until ctx is done. The returned function releases the lock.
+func acquireLock(ctx context.Context, path string, timeout time.Duration) (func(), error) {
+	lock, err := flock.Acquire(ctx, path, timeout, nil)
+	if err != nil {
+		if errors.Is(err, flock.ErrContended) {
+			return nil, fmt.Errorf("%w: %s (waited %v)", ErrLockContended, path, timeout)
+		}
+		return nil, fmt.Errorf("error acquiring mender lock: %w", err)
+	}
+
+	logging.Debugf("Acquired mender lock %s", path)
+	return func() {
+		lock.Release()
+		logging.Debugf("Released mender lock %s", path)
+	}, nil
+}
+
+// lock acquires the configured mender lock, if any
+func (c *Client) lock(ctx context.Context) (func(), error) {
+	if c.lockFile == "" {
+		return func() {}, nil
+	}
+	return acquireLock(ctx, c.lockFile, c.lockTimeout)
+}
@@ -0,0 +1,31 @@
+package mender
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/librescoot/smut/pkg/flock"
+)
+
+func TestLockContended(t *testing.T) {
+	fakeMenderUpdate(t, `exit 0`)
+	path := filepath.Join(t.TempDir(), "mender.lock")
+	held, err := flock.Acquire(context.Background(), path, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClient()
+	c.SetLockFile(path, 50*time.Millisecond)
+	if err := c.Install(context.Background(), "/tmp/update.mender"); !errors.Is(err, ErrLockContended) {
+		t.Errorf("Install while locked = %v, want ErrLockContended", err)
+	}
+
+	held.Release()
+	if err := c.Install(context.Background(), "/tmp/update.mender"); err != nil {
+		t.Errorf("Install after release = %v", err)
+	}
+}
@@ -1,20 +1,111 @@
 package mender
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/librescoot/smut/pkg/logging"
 )
 
-type Client struct{}
+type Client struct {
+	lockFile    string
+	lockTimeout time.Duration
+
+	// onProgress is called with the install progress parsed from mender-update
+	onProgress ProgressFunc
+
+	// verifyKey is the public key artifact signatures are verified against, empty disables
+	verifyKey string
+}
+
+// ErrNoUpdateInProgress is returned by Commit, Resume and Rollback when
+// mender-update has no update in progress to act on
+var ErrNoUpdateInProgress = errors.New("no update in progress")
+
+// noUpdateInProgressStatus is the exit status of mender-update commit, resume
+// and rollback when there is no update in progress
+const noUpdateInProgressStatus = 2
+
+// ErrSignatureVerification is returned, wrapped in an *InstallError, when
+// mender-update rejects an artifact because it is unsigned or its signature
+// does not verify
+var ErrSignatureVerification = errors.New("artifact signature verification failed")
+
+// SetVerifyKey makes Install require artifacts signed with the private key
+// matching the public key PEM at path. An empty path disables verification.
+func (c *Client) SetVerifyKey(path string) {
+	c.verifyKey = path
+}
+
+// InstallError is returned when mender-update fails to install an artifact.
+// Stderr and Stdout hold the end of its output, capped at maxOutputSize each.
+type InstallError struct {
+	Err    error
+	Stderr string
+	Stdout string
+}
+
+func (e *InstallError) Error() string {
+	msg := fmt.Sprintf("error running mender-update install: %v, stderr: %s", e.Err, strings.TrimSpace(e.Stderr))
+	if tail := lastLines(e.Stdout, errorStdoutLines); tail != "" {
+		msg += ", stdout: " + tail
+	}
+	return msg
+}
+
+func (e *InstallError) Unwrap() error {
+	return e.Err
+}
+
+// commandWaitDelay bounds how long a killed mender-update may keep its output
+// pipes open, e.g. through a child process that outlived it
+const commandWaitDelay = 5 * time.Second
+
+// command prepares mender-update with args. As soon as ctx is done the
+// process is killed together with its children, such as state scripts, so
+// canceling smut does not leave any of them running.
+func command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "mender-update", args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
+}
+
+// commandError wraps the error of a mender-update command other than
+// install, telling ErrNoUpdateInProgress apart from real failures
+func commandError(ctx context.Context, action string, err error, stderr string) error {
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == noUpdateInProgressStatus {
+		return fmt.Errorf("mender-update %s: %w", action, ErrNoUpdateInProgress)
+	}
+	return fmt.Errorf("error running mender-update %s: %w, stderr: %s", action, err, stderr)
+}
 
 func NewClient() *Client {
 	return &Client{}
 }
 
-func (c *Client) NeedsCommit() (bool, error) {
-	// cmd := exec.Command("mender-update", "show-artifact")
+// SetLockFile configures a file that is flock'ed around mender operations to
+// coordinate with other mender users on the device. An empty path disables locking.
+func (c *Client) SetLockFile(path string, timeout time.Duration) {
+	c.lockFile = path
+	c.lockTimeout = timeout
+}
+
+func (c *Client) NeedsCommit(ctx context.Context) (bool, error) {
+	// cmd := command(ctx, "show-artifact")
 	// var stdout, stderr bytes.Buffer
 	// cmd.Stdout = &stdout
 	// cmd.Stderr = &stderr
@@ -31,34 +122,131 @@ func (c *Client) NeedsCommit() (bool, error) {
 	return true, nil
 }
 
-func (c *Client) Install(filePath string) error {
-	log.Printf("Installing update from %s", filePath)
-	cmd := exec.Command("mender-update", "install", filePath)
-	var stdout, stderr bytes.Buffer
+// CurrentArtifactName returns the name of the installed artifact as reported
+// by mender-update show-artifact
+func (c *Client) CurrentArtifactName(ctx context.Context) (string, error) {
+	cmd := command(ctx, "show-artifact")
+	var stdout, stderr tailBuffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return "", fmt.Errorf("error running mender-update show-artifact: %w, stderr: %s", err, stderr.String())
+	}
+
+	name := strings.TrimSpace(stdout.String())
+	if name == "" || name == "Unknown" {
+		return "", fmt.Errorf("mender-update show-artifact reported no installed artifact")
+	}
+	return name, nil
+}
+
+// Install installs the artifact at filePath. The mender-update process is
+// killed if ctx is canceled or times out.
+func (c *Client) Install(ctx context.Context, filePath string) error {
+	logging.Infof("Installing update from %s", filePath)
+	unlock, err := c.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	args := []string{"install"}
+	if c.verifyKey != "" {
+		args = append(args, "--verify-key", c.verifyKey)
+	}
+	args = append(args, filePath)
+
+	cmd := command(ctx, args...)
+	var stdout, stderr tailBuffer
 	cmd.Stdout = &stdout
+	if c.onProgress != nil {
+		cmd.Stdout = io.MultiWriter(&stdout, newProgressWriter(c.onProgress))
+	}
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
-		return fmt.Errorf("error running mender-update install: %w, stderr: %s", err, stderr.String())
+		if ctx.Err() != nil {
+			return &InstallError{Err: ctx.Err(), Stderr: stderr.String(), Stdout: stdout.String()}
+		}
+		if c.verifyKey != "" && strings.Contains(strings.ToLower(stderr.String()), "signature") {
+			return &InstallError{Err: fmt.Errorf("%w (%v)", ErrSignatureVerification, err), Stderr: stderr.String(), Stdout: stdout.String()}
+		}
+		return &InstallError{Err: err, Stderr: stderr.String(), Stdout: stdout.String()}
+	}
+
+	logging.Debugf("mender-update install output: %s", stdout.String())
+	return nil
+}
+
+// Commit commits the installed update. The mender-update process is killed
+// if ctx is canceled or times out.
+func (c *Client) Commit(ctx context.Context) error {
+	logging.Infof("Committing update")
+	unlock, err := c.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cmd := command(ctx, "commit")
+	var stdout, stderr tailBuffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return commandError(ctx, "commit", err, stderr.String())
 	}
 
-	log.Printf("mender-update install output: %s", stdout.String())
+	logging.Debugf("mender-update commit output: %s", stdout.String())
 	return nil
 }
 
-func (c *Client) Commit() error {
-	log.Printf("Committing update")
-	cmd := exec.Command("mender-update", "commit")
-	var stdout, stderr bytes.Buffer
+// Resume continues an installation that was interrupted, e.g. by a crash or
+// a power loss, up to the point where it waits for a reboot
+func (c *Client) Resume(ctx context.Context) error {
+	logging.Infof("Resuming interrupted installation")
+	unlock, err := c.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cmd := command(ctx, "resume")
+	var stdout, stderr tailBuffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	if err := cmd.Run(); err != nil {
+		return commandError(ctx, "resume", err, stderr.String())
+	}
+
+	logging.Debugf("mender-update resume output: %s", stdout.String())
+	return nil
+}
+
+// Rollback aborts an installed but uncommitted update, restoring the running artifact
+func (c *Client) Rollback(ctx context.Context) error {
+	logging.Infof("Rolling back update")
+	unlock, err := c.lock(ctx)
 	if err != nil {
-		return fmt.Errorf("error running mender-update commit: %w, stderr: %s", err, stderr.String())
+		return err
+	}
+	defer unlock()
+
+	cmd := command(ctx, "rollback")
+	var stdout, stderr tailBuffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return commandError(ctx, "rollback", err, stderr.String())
 	}
 
-	log.Printf("mender-update commit output: %s", stdout.String())
+	logging.Debugf("mender-update rollback output: %s", stdout.String())
 	return nil
 }
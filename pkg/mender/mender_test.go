@@ -0,0 +1,192 @@
+package mender
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeMenderUpdate puts a mender-update script running body first on PATH
+func fakeMenderUpdate(t *testing.T, body string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" + body + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "mender-update"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestNoUpdateInProgress(t *testing.T) {
+	actions := map[string]func(*Client, context.Context) error{
+		"commit":   (*Client).Commit,
+		"resume":   (*Client).Resume,
+		"rollback": (*Client).Rollback,
+	}
+	for name, action := range actions {
+		t.Run(name, func(t *testing.T) {
+			c := NewClient()
+
+			fakeMenderUpdate(t, `echo "No update in progress" >&2; exit 2`)
+			if err := action(c, context.Background()); !errors.Is(err, ErrNoUpdateInProgress) {
+				t.Errorf("exit status 2 = %v, want ErrNoUpdateInProgress", err)
+			}
+
+			fakeMenderUpdate(t, `echo "broken" >&2; exit 1`)
+			err := action(c, context.Background())
+			if err == nil || errors.Is(err, ErrNoUpdateInProgress) {
+				t.Errorf("exit status 1 = %v, want a plain failure", err)
+			}
+
+			fakeMenderUpdate(t, `exit 0`)
+			if err := action(c, context.Background()); err != nil {
+				t.Errorf("exit status 0 = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestCurrentArtifactName(t *testing.T) {
+	c := NewClient()
+
+	fakeMenderUpdate(t, `[ "$1" = show-artifact ] && echo librescoot-2.4.1-mdb`)
+	name, err := c.CurrentArtifactName(context.Background())
+	if err != nil || name != "librescoot-2.4.1-mdb" {
+		t.Errorf("CurrentArtifactName = %q, %v, want librescoot-2.4.1-mdb", name, err)
+	}
+
+	fakeMenderUpdate(t, `echo Unknown`)
+	if name, err := c.CurrentArtifactName(context.Background()); err == nil {
+		t.Errorf("CurrentArtifactName = %q, want an error for Unknown", name)
+	}
+}
+
+func TestInstallError(t *testing.T) {
+	c := NewClient()
+	var progress []int
+	c.SetProgressCallback(func(percent int) { progress = append(progress, percent) })
+
+	fakeMenderUpdate(t, `echo "Installing Artifact of size 100..."; echo "50%"; echo "disk full" >&2; exit 1`)
+	err := c.Install(context.Background(), "/tmp/update.mender")
+	var installErr *InstallError
+	if !errors.As(err, &installErr) {
+		t.Fatalf("Install = %v, want *InstallError", err)
+	}
+	if installErr.Stderr != "disk full\n" {
+		t.Errorf("Stderr = %q, want the output of mender-update", installErr.Stderr)
+	}
+	if len(progress) != 1 || progress[0] != 50 {
+		t.Errorf("progress = %v, want [50]", progress)
+	}
+}
+
+func TestSignatureVerification(t *testing.T) {
+	argsFile := filepath.Join(t.TempDir(), "args")
+	fakeMenderUpdate(t, `echo "$@" > `+argsFile+`; echo "failed to verify signature" >&2; exit 1`)
+	c := NewClient()
+	c.SetVerifyKey("/etc/mender/artifact-verify-key.pem")
+
+	err := c.Install(context.Background(), "/tmp/update.mender")
+	if !errors.Is(err, ErrSignatureVerification) {
+		t.Errorf("Install = %v, want ErrSignatureVerification", err)
+	}
+	var installErr *InstallError
+	if !errors.As(err, &installErr) || installErr.Stderr != "failed to verify signature\n" {
+		t.Errorf("Install = %#v, want an *InstallError with the output of mender-update", err)
+	}
+	args, _ := os.ReadFile(argsFile)
+	if got, want := string(args), "install --verify-key /etc/mender/artifact-verify-key.pem /tmp/update.mender\n"; got != want {
+		t.Errorf("mender-update called with %q, want %q", got, want)
+	}
+}
+
+func TestCommitTimeout(t *testing.T) {
+	fakeMenderUpdate(t, `sleep 30`)
+	c := NewClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.Commit(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Commit = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestInstallTimeout(t *testing.T) {
+	fakeMenderUpdate(t, `echo "Installing Artifact of size 100..."; sleep 30`)
+	c := NewClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := c.Install(ctx, "/tmp/update.mender")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Install = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Install returned after %v, want mender-update killed at the deadline", elapsed)
+	}
+}
+
+func TestInstallCanceledKillsChildren(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	// A state script that outlives mender-update unless its group is killed
+	fakeMenderUpdate(t, `sleep 30 & echo $! > `+pidFile+`.tmp; mv `+pidFile+`.tmp `+pidFile+`; wait`)
+	c := NewClient()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			if _, err := os.Stat(pidFile); err == nil {
+				cancel()
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	err := c.Install(ctx, "/tmp/update.mender")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Install = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Install returned %v after cancel, want promptly", elapsed)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for running(pid) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("child %d of mender-update still running after cancel", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// running reports whether the process pid is alive, not counting a killed
+// process that is yet to be reaped
+func running(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return syscall.Kill(pid, 0) == nil
+	}
+	// The state follows the parenthesized command name
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
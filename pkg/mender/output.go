@@ -0,0 +1,49 @@
+package mender
+
+import "strings"
+
+// maxOutputSize caps how much of each mender-update output stream is kept,
+// so a chatty process cannot grow errors and the Redis failure key unbounded
+const maxOutputSize = 8 * 1024
+
+// errorStdoutLines is how many trailing stdout lines an InstallError message includes
+const errorStdoutLines = 5
+
+// tailBuffer keeps the last maxOutputSize bytes written to it, which is where
+// mender-update reports why it failed
+type tailBuffer struct {
+	buf       []byte
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - maxOutputSize; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+// String returns the kept output, marked when earlier output was dropped
+func (b *tailBuffer) String() string {
+	if b.truncated {
+		return "[...]" + string(b.buf)
+	}
+	return string(b.buf)
+}
+
+// lastLines returns the last n non-empty lines of s
+func lastLines(s string, n int) string {
+	lines := strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == '\r' })
+	kept := lines[:0]
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) > n {
+		kept = kept[len(kept)-n:]
+	}
+	return strings.Join(kept, "\n")
+}
@@ -0,0 +1,38 @@
+package mender
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTailBuffer(t *testing.T) {
+	var b tailBuffer
+	b.Write([]byte("short"))
+	if got := b.String(); got != "short" {
+		t.Errorf("String() = %q", got)
+	}
+
+	b.Write([]byte(strings.Repeat("x", maxOutputSize)))
+	b.Write([]byte("end"))
+	got := b.String()
+	if !strings.HasPrefix(got, "[...]") || !strings.HasSuffix(got, "end") || len(got) != len("[...]")+maxOutputSize {
+		t.Errorf("String() after overflow has %d bytes, prefix %q", len(got), got[:10])
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"a\nb\nc\n", 2, "b\nc"},
+		{"a\r\n\n  \nb", 5, "a\nb"},
+		{"", 3, ""},
+	}
+	for _, tt := range tests {
+		if got := lastLines(tt.in, tt.n); got != tt.want {
+			t.Errorf("lastLines(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package mender
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+)
+
+// ProgressFunc is called with the install progress in percent
+type ProgressFunc func(percent int)
+
+// SetProgressCallback sets a function that is called whenever the progress
+// reported by mender-update install changes
+func (c *Client) SetProgressCallback(fn ProgressFunc) {
+	c.onProgress = fn
+}
+
+// percentPattern matches a percentage such as " 42%" in mender-update output
+var percentPattern = regexp.MustCompile(`(?:^|[^\d.])(\d{1,3})%`)
+
+// progressWriter scans mender-update output for percentages as it is
+// written. Progress bars redraw with carriage returns, so both '\r' and '\n'
+// end a line. Lines without a percentage are ignored.
+type progressWriter struct {
+	onProgress ProgressFunc
+	line       []byte
+	last       int
+}
+
+func newProgressWriter(fn ProgressFunc) *progressWriter {
+	return &progressWriter{onProgress: fn, last: -1}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexAny(p, "\r\n")
+		if i < 0 {
+			w.line = append(w.line, p...)
+			break
+		}
+		w.line = append(w.line, p[:i]...)
+		w.parseLine()
+		p = p[i+1:]
+	}
+	// Also report a partially written line, bars are often drawn without a line end
+	if len(w.line) > 0 {
+		w.report(parsePercent(w.line))
+	}
+	return n, nil
+}
+
+func (w *progressWriter) parseLine() {
+	w.report(parsePercent(w.line))
+	w.line = w.line[:0]
+}
+
+func (w *progressWriter) report(percent int) {
+	if percent < 0 || percent == w.last {
+		return
+	}
+	w.last = percent
+	w.onProgress(percent)
+}
+
+// parsePercent returns the last percentage in line, or -1 if it has none
+func parsePercent(line []byte) int {
+	matches := percentPattern.FindAllSubmatch(line, -1)
+	if matches == nil {
+		return -1
+	}
+	percent, err := strconv.Atoi(string(matches[len(matches)-1][1]))
+	if err != nil || percent > 100 {
+		return -1
+	}
+	return percent
+}
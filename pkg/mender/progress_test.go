@@ -0,0 +1,28 @@
+package mender
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProgressWriter(t *testing.T) {
+	var got []int
+	w := newProgressWriter(func(percent int) { got = append(got, percent) })
+
+	// A progress bar redrawn with carriage returns, split across writes
+	for _, chunk := range []string{
+		"Installing Artifact of size 1048576...\n",
+		"..........   1%\r....",
+		"......  10%\r",
+		"  10%\r",
+		"version 1.2% off\n",
+		"100%\n",
+	} {
+		w.Write([]byte(chunk))
+	}
+
+	want := []int{1, 10, 100}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("progress = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// shutdownTimeout bounds how long in-flight scrapes may take on shutdown
+const shutdownTimeout = 5 * time.Second
+
+// Metrics collects update counters and exposes them in the Prometheus text
+// format, written by hand to keep the client library out of the binary.
+// A nil *Metrics is valid and records nothing.
+type Metrics struct {
+	mu               sync.Mutex
+	downloads        uint64
+	downloadFailures uint64
+	downloadBytes    uint64
+	installs         uint64
+	installFailures  uint64
+	status           map[string]string // Current status by component
+}
+
+// New creates an empty metrics collector
+func New() *Metrics {
+	return &Metrics{status: make(map[string]string)}
+}
+
+// DownloadSucceeded records a completed download of size bytes
+func (m *Metrics) DownloadSucceeded(size int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.downloads++
+	if size > 0 {
+		m.downloadBytes += uint64(size)
+	}
+}
+
+// DownloadFailed records a failed download
+func (m *Metrics) DownloadFailed() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.downloadFailures++
+}
+
+// InstallSucceeded records a successful install
+func (m *Metrics) InstallSucceeded() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.installs++
+}
+
+// InstallFailed records a failed install
+func (m *Metrics) InstallFailed() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.installFailures++
+}
+
+// SetStatus records the current OTA status of a component
+func (m *Metrics) SetStatus(component, status string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status[component] = status
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	counters := map[string]struct {
+		help  string
+		value uint64
+	}{
+		"smut_downloads_total":         {"Completed artifact downloads.", m.downloads},
+		"smut_download_failures_total": {"Failed artifact downloads.", m.downloadFailures},
+		"smut_download_bytes":          {"Bytes of completed artifact downloads.", m.downloadBytes},
+		"smut_installs_total":          {"Successful artifact installs.", m.installs},
+		"smut_install_failures_total":  {"Failed artifact installs.", m.installFailures},
+	}
+	components := make([]string, 0, len(m.status))
+	for component := range m.status {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	statuses := make([]string, len(components))
+	for i, component := range components {
+		statuses[i] = m.status[component]
+	}
+	m.mu.Unlock()
+
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var total int64
+	write := func(format string, args ...interface{}) error {
+		n, err := fmt.Fprintf(w, format, args...)
+		total += int64(n)
+		return err
+	}
+	for _, name := range names {
+		c := counters[name]
+		if err := write("# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, c.help, name, name, c.value); err != nil {
+			return total, err
+		}
+	}
+	if len(components) > 0 {
+		if err := write("# HELP smut_status Current OTA status per component, the series with value 1.\n# TYPE smut_status gauge\n"); err != nil {
+			return total, err
+		}
+	}
+	for i, component := range components {
+		if err := write("smut_status{component=%q,status=%q} 1\n", component, statuses[i]); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Serve exposes /metrics and /healthz on addr until ctx is done. /healthz
+// answers 200 while health returns nil and 503 otherwise.
+func (m *Metrics) Serve(ctx context.Context, addr string, health func(ctx context.Context) error) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.WriteTo(w)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := health(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logging.Warnf("Failed to shut down metrics server: %v", err)
+		}
+	}()
+
+	logging.Infof("Serving metrics on %s", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("metrics server failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriteTo(t *testing.T) {
+	m := New()
+	m.DownloadSucceeded(100)
+	m.DownloadSucceeded(50)
+	m.DownloadFailed()
+	m.InstallSucceeded()
+	m.InstallFailed()
+	m.InstallFailed()
+	m.SetStatus("mdb", "downloading")
+	m.SetStatus("dbc", "idle")
+
+	var sb strings.Builder
+	n, err := m.WriteTo(&sb)
+	if err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	out := sb.String()
+	if n != int64(len(out)) {
+		t.Errorf("WriteTo() = %d, wrote %d bytes", n, len(out))
+	}
+	for _, line := range []string{
+		"smut_downloads_total 2\n",
+		"smut_download_failures_total 1\n",
+		"smut_download_bytes 150\n",
+		"smut_installs_total 1\n",
+		"smut_install_failures_total 2\n",
+		"# TYPE smut_status gauge\n",
+		"smut_status{component=\"dbc\",status=\"idle\"} 1\nsmut_status{component=\"mdb\",status=\"downloading\"} 1\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("output lacks %q:\n%s", line, out)
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.DownloadSucceeded(1)
+	m.DownloadFailed()
+	m.InstallSucceeded()
+	m.InstallFailed()
+	m.SetStatus("dbc", "idle")
+}
+
+func TestServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	m := New()
+	m.InstallSucceeded()
+	var unhealthy atomic.Bool
+	health := func(ctx context.Context) error {
+		if unhealthy.Load() {
+			return errors.New("redis unreachable")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- m.Serve(ctx, addr, health) }()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		var resp *http.Response
+		var err error
+		for i := 0; i < 100; i++ {
+			resp, err = http.Get(fmt.Sprintf("http://%s%s", addr, path))
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, body := get("/metrics"); code != http.StatusOK || !strings.Contains(body, "smut_installs_total 1") {
+		t.Errorf("/metrics = %d %q", code, body)
+	}
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz = %d, want 200", code)
+	}
+	unhealthy.Store(true)
+	if code, body := get("/healthz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "redis unreachable") {
+		t.Errorf("/healthz = %d %q, want 503", code, body)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve() = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Serve() did not return after cancel")
+	}
+}
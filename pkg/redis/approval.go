@@ -0,0 +1,73 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// consumeApprovalScript deletes the approval key only while it still holds the
+// approving value, so an approval set for another update meanwhile is kept
+var consumeApprovalScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// approvalPollInterval is how often the approval key is read again, in case
+// it is set without a message on its channel
+const approvalPollInterval = 5 * time.Second
+
+// WaitForApproval blocks until key is set to one of ids, or one of ids is
+// published on the channel named like key, returning early if ctx is
+// canceled. Empty ids are ignored. An approval by key is consumed, so it does
+// not approve the same artifact again if it is pushed once more.
+func (c *Client) WaitForApproval(ctx context.Context, key string, ids ...string) error {
+	approves := func(value string) bool {
+		value = strings.TrimSpace(value)
+		for _, id := range ids {
+			if id != "" && value == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Subscribe before the first read, so an approval published in between
+	// is not missed
+	pubsub := c.client.Subscribe(ctx, key)
+	defer pubsub.Close()
+	messages := pubsub.Channel()
+
+	ticker := time.NewTicker(approvalPollInterval)
+	defer ticker.Stop()
+	for {
+		value, err := c.client.Get(ctx, key).Result()
+		if err != nil && err != redis.Nil && ctx.Err() == nil {
+			// Keep waiting, the subscription and the next poll may still succeed
+			logging.Warnf("Failed to get approval from Redis: %v", err)
+		}
+		if approves(value) {
+			logging.Infof("Update approved by key %s", key)
+			if err := consumeApprovalScript.Run(ctx, c.client, []string{key}, value).Err(); err != nil {
+				logging.Warnf("Failed to clear approval key %s in Redis: %v", key, err)
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-messages:
+			if ok && approves(msg.Payload) {
+				logging.Infof("Update approved by message on channel %s", key)
+				return nil
+			}
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitForSubscriber waits until channel has a subscriber on s
+func waitForSubscriber(t *testing.T, s *fakeServer, channel string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.subscribers(channel) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("nobody subscribed to %s", channel)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWaitForApprovalByMessage(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.WaitForApproval(context.Background(), "ota/approve", "v2", "sha256:abc")
+	}()
+	waitForSubscriber(t, s, "ota/approve")
+
+	s.publish("ota/approve", "v1")
+	select {
+	case err := <-done:
+		t.Fatalf("WaitForApproval() returned %v for another artifact", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.publish("ota/approve", " v2\n")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WaitForApproval() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitForApproval() did not return after approval")
+	}
+}
+
+func TestWaitForApprovalByKey(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	s.setString("ota/approve", "sha256:abc")
+
+	if err := c.WaitForApproval(context.Background(), "ota/approve", "v2", "sha256:abc"); err != nil {
+		t.Fatalf("WaitForApproval() error = %v", err)
+	}
+	if v, ok := s.getString("ota/approve"); ok {
+		t.Errorf("approval key = %q after the approval, want it consumed", v)
+	}
+}
+
+func TestWaitForApprovalCanceled(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	s.setString("ota/approve", "v1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.WaitForApproval(ctx, "ota/approve", "v2", ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitForApproval() error = %v, want deadline exceeded", err)
+	}
+	if v, _ := s.getString("ota/approve"); v != "v1" {
+		t.Errorf("approval key = %q, want the approval of another artifact kept", v)
+	}
+}
@@ -0,0 +1,39 @@
+package redis
+
+import (
+	"context"
+	"strings"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// SubscribeCommands subscribes to a control channel and returns the commands
+// published on it, trimmed and lowercased. The channel is closed once ctx is
+// canceled. go-redis resubscribes by itself after a lost connection.
+func (c *Client) SubscribeCommands(ctx context.Context, channel string) <-chan string {
+	pubsub := c.client.Subscribe(ctx, channel)
+	logging.Infof("Listening for commands on channel %s", channel)
+
+	commands := make(chan string)
+	go func() {
+		defer close(commands)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case commands <- strings.ToLower(strings.TrimSpace(msg.Payload)):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return commands
+}
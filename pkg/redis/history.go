@@ -0,0 +1,44 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// StatusHistoryEntry is one status change in the status history list
+type StatusHistoryEntry struct {
+	Status    string    `json:"status"`
+	Component string    `json:"component,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// SetStatusHistory makes SetStatus push every status onto the list at key,
+// newest first, trimmed to length entries. A length of 0 disables the history.
+func (c *Client) SetStatusHistory(key string, length int) {
+	c.historyKey = key
+	c.historyLen = length
+}
+
+// recordStatus appends status to the status history, if enabled
+func (c *Client) recordStatus(ctx context.Context, status string) error {
+	if c.historyKey == "" || c.historyLen <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(StatusHistoryEntry{Status: status, Component: c.component, Timestamp: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, c.historyKey, data)
+		pipe.LTrim(ctx, c.historyKey, 0, int64(c.historyLen)-1)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push status onto %s in Redis: %w", c.historyKey, err)
+	}
+	return nil
+}
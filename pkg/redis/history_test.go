@@ -0,0 +1,50 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestStatusHistory(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	c.SetComponent("dbc")
+	c.SetStatusHistory("ota/status-history", 3)
+
+	statuses := []string{"downloading-updates", "download-complete", "installing-updates", "installation-complete-waiting-reboot"}
+	for _, status := range statuses {
+		if err := c.SetStatus(context.Background(), status); err != nil {
+			t.Fatalf("SetStatus() error = %v", err)
+		}
+	}
+
+	history := s.list("ota/status-history")
+	if len(history) != 3 {
+		t.Fatalf("history has %d entries, want 3: %q", len(history), history)
+	}
+	// Newest first, the oldest status was trimmed
+	for i, raw := range history {
+		var entry StatusHistoryEntry
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+			t.Fatalf("history entry %q: %v", raw, err)
+		}
+		want := statuses[len(statuses)-1-i]
+		if entry.Status != want || entry.Component != "dbc" || entry.Timestamp.IsZero() {
+			t.Errorf("history[%d] = %+v, want %s", i, entry, want)
+		}
+	}
+}
+
+func TestStatusHistoryDisabled(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	c.SetStatusHistory("ota/status-history", 0)
+
+	if err := c.SetStatus(context.Background(), "downloading-updates"); err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+	if history := s.list("ota/status-history"); len(history) != 0 {
+		t.Errorf("history = %q, want none", history)
+	}
+}
@@ -0,0 +1,17 @@
+package redis
+
+import "context"
+
+// ClientInterface is the part of Client that binaries depend on to report
+// status and take updates. Narrower interfaces for single steps are declared
+// by their consumers.
+type ClientInterface interface {
+	SetStatus(ctx context.Context, status string) error
+	SetUpdateType(ctx context.Context, updateType string) error
+	SetFailure(ctx context.Context, key string, failure Failure) error
+	GetChecksum(ctx context.Context, key string) (string, error)
+	WaitForUpdate(ctx context.Context, updateKey string, checksumKey string) (UpdateRequest, error)
+	Close() error
+}
+
+var _ ClientInterface = (*Client)(nil)
@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// ErrLockHeld is returned by AcquireLock when another updater holds the lock
+var ErrLockHeld = errors.New("lock is held by another updater")
+
+// The lock is only refreshed or released by the holder of its token
+var (
+	refreshLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// Lock is a lease on a Redis key that keeps concurrent updaters from
+// handling updates at the same time. It is refreshed in the background
+// until released, so it only expires if its holder dies.
+type Lock struct {
+	client *redis.Client
+	key    string
+	token  string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// AcquireLock takes the lock at key with the given TTL, or returns
+// ErrLockHeld if another updater holds it
+func (c *Client) AcquireLock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	token, err := lockToken()
+	if err != nil {
+		return nil, err
+	}
+	ok, err := c.client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock %s in Redis: %w", key, err)
+	}
+	if !ok {
+		return nil, ErrLockHeld
+	}
+	logging.Debugf("Acquired lock %s", key)
+
+	refreshCtx, cancel := context.WithCancel(context.Background())
+	l := &Lock{client: c.client, key: key, token: token, cancel: cancel, done: make(chan struct{})}
+	go l.refresh(refreshCtx, ttl)
+	return l, nil
+}
+
+// refresh extends the lock's TTL every third of it until canceled
+func (l *Lock) refresh(ctx context.Context, ttl time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			held, err := refreshLockScript.Run(ctx, l.client, []string{l.key}, l.token, ttl.Milliseconds()).Int()
+			if err != nil {
+				if ctx.Err() == nil {
+					logging.Warnf("Failed to refresh lock %s: %v", l.key, err)
+				}
+				continue
+			}
+			if held == 0 {
+				logging.Warnf("Lock %s expired while held", l.key)
+			}
+		}
+	}
+}
+
+// Release stops refreshing the lock and deletes it if it is still ours
+func (l *Lock) Release(ctx context.Context) error {
+	l.cancel()
+	<-l.done
+	if err := releaseLockScript.Run(ctx, l.client, []string{l.key}, l.token).Err(); err != nil {
+		return fmt.Errorf("failed to release lock %s in Redis: %w", l.key, err)
+	}
+	logging.Debugf("Released lock %s", l.key)
+	return nil
+}
+
+// lockToken identifies the lock holder, for debugging by host and pid
+func lockToken() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(b)), nil
+}
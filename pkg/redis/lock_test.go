@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+	s := newFakeServer(t)
+	first := newTestClient(t, s)
+	second := newTestClient(t, s)
+	ctx := context.Background()
+
+	lock, err := first.AcquireLock(ctx, "ota/lock", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLock() error = %v", err)
+	}
+	if _, err := second.AcquireLock(ctx, "ota/lock", time.Minute); !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("second AcquireLock() error = %v, want ErrLockHeld", err)
+	}
+
+	if err := lock.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, ok := s.getString("ota/lock"); ok {
+		t.Error("lock key left after release")
+	}
+	lock, err = second.AcquireLock(ctx, "ota/lock", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLock() after release error = %v", err)
+	}
+	lock.Release(ctx)
+}
+
+func TestReleaseLockTakenOver(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	ctx := context.Background()
+
+	lock, err := c.AcquireLock(ctx, "ota/lock", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLock() error = %v", err)
+	}
+	// The lease expired and another updater took the lock
+	s.setString("ota/lock", "other")
+
+	if err := lock.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if holder, _ := s.getString("ota/lock"); holder != "other" {
+		t.Errorf("lock holder = %q after release, want the other updater's lock kept", holder)
+	}
+}
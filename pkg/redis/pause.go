@@ -0,0 +1,75 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// pausePollInterval is how often the pause key is read again while paused, in
+// case it is cleared without a message on its channel
+const pausePollInterval = 5 * time.Second
+
+// isPaused reports whether value of the pause key pauses updates. Any value
+// but an empty one, "0" or "false" does.
+func isPaused(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "0", "false":
+		return false
+	}
+	return true
+}
+
+// WaitWhilePaused blocks while key is set, so updates can be stopped
+// fleet-wide without stopping the updaters, returning early if ctx is
+// canceled. onHold is called once if it has to wait. The pause is lifted by
+// deleting key or setting it to "0" or "false", and noticed at once if
+// anything is published on the channel named like key. If key cannot be read
+// before pausing, updates are not held.
+func (c *Client) WaitWhilePaused(ctx context.Context, key string, onHold func()) error {
+	value, err := c.client.Get(ctx, key).Result()
+	if err != nil && err != redis.Nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logging.Warnf("Failed to get pause key from Redis: %v", err)
+		return nil
+	}
+	if !isPaused(value) {
+		return nil
+	}
+
+	logging.Infof("Updates paused by key %s, waiting for it to be cleared", key)
+	onHold()
+
+	pubsub := c.client.Subscribe(ctx, key)
+	defer pubsub.Close()
+	messages := pubsub.Channel()
+
+	ticker := time.NewTicker(pausePollInterval)
+	defer ticker.Stop()
+	for {
+		// Read again after subscribing, so a clear in between is not missed
+		value, err := c.client.Get(ctx, key).Result()
+		if err != nil && err != redis.Nil {
+			if ctx.Err() == nil {
+				// Stay paused, the subscription and the next poll may still succeed
+				logging.Warnf("Failed to get pause key from Redis: %v", err)
+			}
+		} else if !isPaused(value) {
+			logging.Infof("Updates resumed by key %s", key)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-messages:
+		case <-ticker.C:
+		}
+	}
+}
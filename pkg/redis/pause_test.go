@@ -0,0 +1,54 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIsPaused(t *testing.T) {
+	for value, want := range map[string]bool{
+		"":       false,
+		"0":      false,
+		"false":  false,
+		" FALSE": false,
+		"1":      true,
+		"true":   true,
+		"fleet":  true,
+	} {
+		if got := isPaused(value); got != want {
+			t.Errorf("isPaused(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestWaitWhilePaused(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+
+	held := 0
+	if err := c.WaitWhilePaused(context.Background(), "ota/pause", func() { held++ }); err != nil || held != 0 {
+		t.Fatalf("WaitWhilePaused() without pause = %v, held %d times", err, held)
+	}
+
+	s.setString("ota/pause", "1")
+	done := make(chan error, 1)
+	go func() {
+		done <- c.WaitWhilePaused(context.Background(), "ota/pause", func() { held++ })
+	}()
+	waitForSubscriber(t, s, "ota/pause")
+
+	s.setString("ota/pause", "0")
+	s.publish("ota/pause", "resume")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WaitWhilePaused() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitWhilePaused() did not return after the pause was cleared")
+	}
+	if held != 1 {
+		t.Errorf("onHold called %d times, want 1", held)
+	}
+}
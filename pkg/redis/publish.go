@@ -0,0 +1,49 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// PublishField publishes only the name of the changed field (default)
+	PublishField = "field"
+	// PublishJSON publishes a JSON event carrying the field and its new value
+	PublishJSON = "json"
+)
+
+// statusEvent is the payload published in PublishJSON mode
+type statusEvent struct {
+	Field     string `json:"field"`
+	Value     string `json:"value"`
+	Component string `json:"component,omitempty"`
+	Timestamp int64  `json:"ts"`
+}
+
+// SetPublishPayload selects what is published on the hash channel when a
+// field changes: the field name (PublishField) or a JSON event (PublishJSON)
+func (c *Client) SetPublishPayload(mode string) error {
+	if mode != PublishField && mode != PublishJSON {
+		return fmt.Errorf("invalid publish payload '%s', must be '%s' or '%s'", mode, PublishField, PublishJSON)
+	}
+	c.publishPayload = mode
+	return nil
+}
+
+// publishMessage builds the message announcing that field changed to value
+func (c *Client) publishMessage(field, value string) string {
+	if c.publishPayload != PublishJSON {
+		return field
+	}
+	data, err := json.Marshal(statusEvent{
+		Field:     field,
+		Value:     value,
+		Component: c.component,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return field
+	}
+	return string(data)
+}
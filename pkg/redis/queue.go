@@ -0,0 +1,115 @@
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultMaxEntryLength is the default upper bound for a raw update list entry
+const DefaultMaxEntryLength = 4096
+
+// ErrMalformedEntry is returned when the update list only held malformed entries
+var ErrMalformedEntry = errors.New("malformed update entry")
+
+// UpdateRequest is a single entry of the update list. Entries are either a
+// bare URL or a JSON descriptor such as {"url": "...", "priority": 10}, which
+// may also carry the checksum, update type and version of the artifact.
+type UpdateRequest struct {
+	URL        string `json:"url"`
+	Priority   int    `json:"priority,omitempty"`
+	Checksum   string `json:"checksum,omitempty"`    // overrides the checksum key
+	UpdateType string `json:"update_type,omitempty"` // blocking or non-blocking, overrides --update-type
+	Version    string `json:"version,omitempty"`     // expected artifact name
+}
+
+// Encode returns the entry as pushed to the update list: the bare URL if
+// nothing else is set, a JSON descriptor otherwise
+func (r UpdateRequest) Encode() string {
+	if r == (UpdateRequest{URL: r.URL}) {
+		return r.URL
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return r.URL
+	}
+	return string(data)
+}
+
+// parseUpdateRequest parses and validates a raw update list entry
+func parseUpdateRequest(raw string, maxLength int) (UpdateRequest, error) {
+	if maxLength > 0 && len(raw) > maxLength {
+		return UpdateRequest{}, fmt.Errorf("entry length %d exceeds maximum of %d", len(raw), maxLength)
+	}
+	if !utf8.ValidString(raw) {
+		return UpdateRequest{}, fmt.Errorf("entry is not valid UTF-8")
+	}
+
+	raw = strings.TrimSpace(raw)
+	req := UpdateRequest{URL: raw}
+	if strings.HasPrefix(raw, "{") {
+		if err := json.Unmarshal([]byte(raw), &req); err != nil {
+			return UpdateRequest{}, fmt.Errorf("invalid JSON update entry: %w", err)
+		}
+		if req.URL == "" {
+			return UpdateRequest{}, fmt.Errorf("JSON update entry has no url")
+		}
+		if req.UpdateType != "" && req.UpdateType != "blocking" && req.UpdateType != "non-blocking" {
+			return UpdateRequest{}, fmt.Errorf("invalid update_type '%s', must be 'blocking' or 'non-blocking'", req.UpdateType)
+		}
+	}
+
+	if err := validateURL(req.URL); err != nil {
+		return UpdateRequest{}, err
+	}
+	return req, nil
+}
+
+// validateURL checks that each mirror of an update URL is parseable and has a
+// scheme the downloader handles
+func validateURL(rawURL string) error {
+	mirrors := 0
+	for _, mirror := range strings.Split(rawURL, ",") {
+		mirror = strings.TrimSpace(mirror)
+		if mirror == "" {
+			continue
+		}
+		mirrors++
+		u, err := url.Parse(mirror)
+		if err != nil {
+			return fmt.Errorf("invalid URL: %w", err)
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https", "file", "s3":
+		case "":
+			return fmt.Errorf("URL has no scheme")
+		default:
+			return fmt.Errorf("unsupported URL scheme '%s'", u.Scheme)
+		}
+	}
+	if mirrors == 0 {
+		return fmt.Errorf("URL is empty")
+	}
+	return nil
+}
+
+// isBlankEntry reports whether a raw update list entry holds nothing but whitespace
+func isBlankEntry(raw string) bool {
+	return strings.TrimSpace(raw) == ""
+}
+
+// selectUpdateRequest returns the entry with the highest priority. Among
+// entries with equal priority the one drained last wins, which matches the
+// behaviour for plain URL entries (priority 0).
+func selectUpdateRequest(entries []UpdateRequest) UpdateRequest {
+	var best UpdateRequest
+	for i, entry := range entries {
+		if i == 0 || entry.Priority >= best.Priority {
+			best = entry
+		}
+	}
+	return best
+}
@@ -0,0 +1,204 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUpdateRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    UpdateRequest
+		wantErr bool
+	}{
+		{name: "plain URL", raw: "https://example.com/a.mender", want: UpdateRequest{URL: "https://example.com/a.mender"}},
+		{name: "surrounding whitespace", raw: "  file:///data/a.mender\n", want: UpdateRequest{URL: "file:///data/a.mender"}},
+		{name: "mirrors", raw: "https://a/x.mender, https://b/x.mender", want: UpdateRequest{URL: "https://a/x.mender, https://b/x.mender"}},
+		{
+			name: "JSON descriptor",
+			raw:  `{"url": "https://example.com/a.mender", "checksum": "sha256:abc", "update_type": "blocking", "version": "v2", "priority": 5}`,
+			want: UpdateRequest{URL: "https://example.com/a.mender", Checksum: "sha256:abc", UpdateType: "blocking", Version: "v2", Priority: 5},
+		},
+		{name: "invalid JSON", raw: `{"url": `, wantErr: true},
+		{name: "JSON without url", raw: `{"checksum": "sha256:abc"}`, wantErr: true},
+		{name: "JSON with unknown update type", raw: `{"url": "https://example.com/a.mender", "update_type": "now"}`, wantErr: true},
+		{name: "no scheme", raw: "example.com/a.mender", wantErr: true},
+		{name: "unsupported scheme", raw: "ftp://example.com/a.mender", wantErr: true},
+		{name: "too long", raw: "https://example.com/" + strings.Repeat("a", DefaultMaxEntryLength), wantErr: true},
+		{name: "invalid UTF-8", raw: "https://example.com/\xff", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseUpdateRequest(tt.raw, DefaultMaxEntryLength)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseUpdateRequest() = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseUpdateRequest() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseUpdateRequest() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitForUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		entries  []string
+		checksum string
+		want     UpdateRequest
+		wantErr  error
+		left     []string
+	}{
+		{
+			name:     "last plain URL wins",
+			entries:  []string{"https://example.com/old.mender", "https://example.com/new.mender"},
+			checksum: "sha256:abc",
+			want:     UpdateRequest{URL: "https://example.com/new.mender", Checksum: "sha256:abc"},
+		},
+		{
+			name:    "garbage is skipped",
+			entries: []string{"", "https://example.com/a.mender", "not a url", "   ", `{"url":`},
+			want:    UpdateRequest{URL: "https://example.com/a.mender"},
+		},
+		{
+			name:     "descriptor checksum overrides the checksum key",
+			entries:  []string{`{"url": "https://example.com/a.mender", "checksum": "sha256:def", "update_type": "blocking"}`},
+			checksum: "sha256:abc",
+			want:     UpdateRequest{URL: "https://example.com/a.mender", Checksum: "sha256:def", UpdateType: "blocking"},
+		},
+		{
+			name: "highest priority wins",
+			entries: []string{
+				`{"url": "https://example.com/urgent.mender", "priority": 10}`,
+				"https://example.com/a.mender",
+			},
+			want: UpdateRequest{URL: "https://example.com/urgent.mender", Priority: 10},
+			left: []string{"https://example.com/a.mender"},
+		},
+		{
+			name: "lower priorities are pushed back in order",
+			entries: []string{
+				"https://example.com/a.mender",
+				`{"url": "https://example.com/urgent.mender", "priority": 10}`,
+				`{"url": "https://example.com/b.mender", "priority": 5}`,
+				"not a url",
+				"https://example.com/c.mender",
+			},
+			want: UpdateRequest{URL: "https://example.com/urgent.mender", Priority: 10},
+			left: []string{
+				"https://example.com/a.mender",
+				`{"url": "https://example.com/b.mender", "priority": 5}`,
+				"https://example.com/c.mender",
+			},
+		},
+		{
+			name:    "only garbage",
+			entries: []string{"not a url", "ftp://example.com/a.mender"},
+			wantErr: ErrMalformedEntry,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t)
+			c := newTestClient(t, s)
+			s.rpush("updates", tt.entries...)
+			if tt.checksum != "" {
+				s.setString("checksum", tt.checksum)
+			}
+
+			got, err := c.WaitForUpdate(context.Background(), "updates", "checksum")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("WaitForUpdate() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("WaitForUpdate() error = %v", err)
+			} else if got != tt.want {
+				t.Errorf("WaitForUpdate() = %+v, want %+v", got, tt.want)
+			}
+			if left := s.list("updates"); strings.Join(left, "\n") != strings.Join(tt.left, "\n") {
+				t.Errorf("entries left on the list: %q, want %q", left, tt.left)
+			}
+		})
+	}
+}
+
+func TestRequeueUpdate(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	update := UpdateRequest{URL: "https://example.com/a.mender"}
+	s.rpush("updates", "https://example.com/b.mender")
+	if err := c.RequeueUpdate(context.Background(), "updates", update); err != nil {
+		t.Fatalf("RequeueUpdate() error = %v", err)
+	}
+	// The requeued update goes to the head of the list
+	if got := s.list("updates"); len(got) != 2 || got[0] != update.URL {
+		t.Errorf("update list = %v, want %s first", got, update.URL)
+	}
+}
+
+func TestPeekUpdateDoesNotRemoveEntries(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	entries := []string{"https://example.com/old.mender", "garbage", "https://example.com/new.mender"}
+	s.rpush("updates", entries...)
+
+	got, n, err := c.PeekUpdate(context.Background(), "updates")
+	if err != nil {
+		t.Fatalf("PeekUpdate() error = %v", err)
+	}
+	if got == nil || got.URL != "https://example.com/new.mender" || n != len(entries) {
+		t.Errorf("PeekUpdate() = %+v, %d, want new.mender of %d entries", got, n, len(entries))
+	}
+	if left := s.list("updates"); len(left) != len(entries) {
+		t.Errorf("list = %q after peek, want all %d entries", left, len(entries))
+	}
+
+	got, n, err = c.PeekUpdate(context.Background(), "empty")
+	if err != nil || got != nil || n != 0 {
+		t.Errorf("PeekUpdate() of an empty list = %+v, %d, %v", got, n, err)
+	}
+}
+
+func TestUpdateRequestEncodeRoundTrip(t *testing.T) {
+	for _, req := range []UpdateRequest{
+		{URL: "https://example.com/a.mender"},
+		{URL: "https://example.com/a.mender", Checksum: "sha256:abc", Version: "v2"},
+	} {
+		encoded := req.Encode()
+		if req.Checksum == "" && encoded != req.URL {
+			t.Errorf("Encode() = %q, want the bare URL", encoded)
+		}
+		got, err := parseUpdateRequest(encoded, DefaultMaxEntryLength)
+		if err != nil || got != req {
+			t.Errorf("parseUpdateRequest(Encode()) = %+v, %v, want %+v", got, err, req)
+		}
+	}
+}
+
+func TestWaitForUpdateWaitsPastEmptyEntries(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	s.rpush("updates", "", "   ")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.rpush("updates", "https://example.com/a.mender")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := c.WaitForUpdate(ctx, "updates", "")
+	if err != nil || got.URL != "https://example.com/a.mender" {
+		t.Errorf("WaitForUpdate() = %+v, %v, want the entry pushed after the empty ones", got, err)
+	}
+}
@@ -0,0 +1,107 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+const (
+	reconnectInitialBackoff = time.Second
+	reconnectMaxBackoff     = 30 * time.Second
+)
+
+// IsConnectionError reports whether err means the connection to the server
+// was lost, as opposed to a command error or cancellation
+func IsConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, redis.ErrClosed) ||
+		errors.As(err, &netErr)
+}
+
+// connect pings the server until it answers, up to attempts times and for at
+// most timeout, backing off exponentially between attempts. It lets smut start
+// before Redis at boot instead of failing.
+func connect(ctx context.Context, client *redis.Client, attempts int, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	backoff := reconnectInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := client.Ping(ctx).Err()
+		if err == nil {
+			if attempt > 1 {
+				logging.Infof("Connected to Redis after %d attempts", attempt)
+			}
+			return nil
+		}
+		if attempt >= attempts || ctx.Err() != nil {
+			return connectError(attempt, err)
+		}
+		logging.Warnf("Redis connect attempt %d/%d failed: %v, retrying in %v", attempt, attempts, err, backoff)
+		select {
+		case <-ctx.Done():
+			return connectError(attempt, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, reconnectMaxBackoff)
+	}
+}
+
+// connectError reports the last error of the initial connection attempts
+func connectError(attempts int, err error) error {
+	if attempts > 1 {
+		return fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+	}
+	return err
+}
+
+// Reconnect blocks until the server answers a ping again, backing off
+// exponentially between attempts. The go-redis pool drops broken connections,
+// so each ping dials afresh. If an expected identity is configured it is
+// verified again, in case a different instance came up at the same address.
+func (c *Client) Reconnect(ctx context.Context) error {
+	backoff := reconnectInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := c.client.Ping(ctx).Err()
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logging.Warnf("Redis reconnect attempt %d failed: %v, retrying in %v", attempt, err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+
+	if c.expectID != "" {
+		if err := verifyIdentity(ctx, c.client, c.identityKey, c.expectID); err != nil {
+			return err
+		}
+	}
+	logging.Infof("Reconnected to Redis")
+	return nil
+}
@@ -0,0 +1,84 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{errors.New("WRONGTYPE"), false},
+		{io.EOF, true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+	}
+	for _, tt := range tests {
+		if got := IsConnectionError(tt.err); got != tt.want {
+			t.Errorf("IsConnectionError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWaitForUpdateRecoversFromRestart(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	c.SetBLPopTimeout(time.Second)
+
+	done := make(chan UpdateRequest, 1)
+	go func() {
+		update, err := c.WaitForUpdate(context.Background(), "updates", "")
+		if err != nil {
+			t.Errorf("WaitForUpdate() error = %v", err)
+		}
+		done <- update
+	}()
+
+	// Drop the connection mid-wait, then come back with an update queued
+	time.Sleep(100 * time.Millisecond)
+	s.stop()
+	time.Sleep(200 * time.Millisecond)
+	s.rpush("updates", "https://example.com/a.mender")
+	s.restart()
+
+	select {
+	case update := <-done:
+		if update.URL != "https://example.com/a.mender" {
+			t.Errorf("WaitForUpdate() = %+v", update)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("WaitForUpdate() did not recover from the restart")
+	}
+}
+
+func TestNewClientWaitsForServer(t *testing.T) {
+	s := newFakeServer(t)
+	s.stop()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		s.restart()
+	}()
+
+	c, err := NewClient(context.Background(), Options{Addr: s.addr, ConnectAttempts: 5, ConnectTimeout: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.Close()
+}
+
+func TestNewClientGivesUp(t *testing.T) {
+	s := newFakeServer(t)
+	s.stop()
+
+	if _, err := NewClient(context.Background(), Options{Addr: s.addr, ConnectAttempts: 1}); err == nil {
+		t.Fatal("NewClient() connected to a stopped server")
+	}
+}
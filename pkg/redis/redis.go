@@ -2,105 +2,296 @@ package redis
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
-	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/librescoot/smut/pkg/logging"
 )
 
 const (
-	// OTAHashKey is the Redis hash key for OTA status and type
+	// OTAHashKey is the default Redis hash key for OTA status and type, and
+	// the channel changes to it are published on
 	OTAHashKey = "ota"
 	// OTAStatusField is the field within the OTA hash for the overall OTA status
 	OTAStatusField = "status"
 	// OTAUpdateTypeField is the field within the OTA hash for the update type (blocking/non-blocking)
 	OTAUpdateTypeField = "update-type"
+	// OTADownloadProgressField is the field within the OTA hash for the download progress in percent
+	OTADownloadProgressField = "download-progress"
+	// OTADownloadAttemptField is the field within the OTA hash for the current download retry attempt
+	OTADownloadAttemptField = "download-attempt"
+	// OTADownloadMaxAttemptsField is the field within the OTA hash for the maximum number of download attempts
+	OTADownloadMaxAttemptsField = "download-max-attempts"
+	// OTAInstallProgressField is the field within the OTA hash for the install progress in percent
+	OTAInstallProgressField = "install-progress"
+	// OTALastVersionField is the field within the OTA hash for the version of the last successful update
+	OTALastVersionField = "last-version"
+	// OTALastURLField is the field within the OTA hash for the URL of the last successful update
+	OTALastURLField = "last-url"
+	// OTALastTimestampField is the field within the OTA hash for the time of the last successful update (RFC 3339)
+	OTALastTimestampField = "last-timestamp"
+	// OTAPendingArtifactNameField is the field within the OTA hash for the name of the artifact being installed
+	OTAPendingArtifactNameField = "pending-artifact-name"
+	// OTAPendingDeviceTypesField is the field within the OTA hash for the comma-separated device types of the artifact being installed
+	OTAPendingDeviceTypesField = "pending-device-types"
+	// OTAPendingPayloadTypeField is the field within the OTA hash for the comma-separated payload types of the artifact being installed
+	OTAPendingPayloadTypeField = "pending-payload-type"
+	// OTALastFingerprintField is the field within the OTA hash for the SHA-256 of the URL and checksum of the last successful update
+	OTALastFingerprintField = "last-fingerprint"
+	// OTALastDownloadSecondsField is the field within the OTA hash for the duration of the last successful download in seconds
+	OTALastDownloadSecondsField = "last-download-seconds"
+	// OTALastInstallSecondsField is the field within the OTA hash for the duration of the last successful install in seconds
+	OTALastInstallSecondsField = "last-install-seconds"
+	// OTAArtifactVersionField is the field within the OTA hash for the version parsed from the filename of the downloaded artifact
+	OTAArtifactVersionField = "artifact-version"
 )
 
 // Client is a Redis client wrapper
 type Client struct {
-	client *redis.Client
-	updateKey string
-	component string
+	client         *redis.Client
+	updateKey      string
+	component      string
+	hashKey        string
+	maxEntryLength int
+	blpopTimeout   time.Duration
+	publishPayload string
+
+	// onStatus is called with the component and every status that is set
+	onStatus func(component, status string)
+
+	// Capped list every status is pushed onto, disabled when historyLen is 0
+	historyKey string
+	historyLen int
+
+	// Identity re-verified after a reconnect
+	expectID    string
+	identityKey string
 }
 
 // SetStatus sets the status field in the ota hash in Redis
 func (c *Client) SetStatus(ctx context.Context, status string) error {
-	err := c.client.HSet(ctx, OTAHashKey, OTAStatusField, status).Err()
+	err := c.client.HSet(ctx, c.hashKey, OTAStatusField, status).Err()
 	if err != nil {
-		return fmt.Errorf("failed to set %s field in %s hash in Redis: %w", OTAStatusField, OTAHashKey, err)
+		return fmt.Errorf("failed to set %s field in %s hash in Redis: %w", OTAStatusField, c.hashKey, err)
+	}
+	logging.Debugf("Set %s field in %s hash to '%s'", OTAStatusField, c.hashKey, status)
+	if c.onStatus != nil {
+		c.onStatus(c.component, status)
+	}
+	if err := c.recordStatus(ctx, status); err != nil {
+		logging.Warnf("Failed to record status history: %v", err)
 	}
-	log.Printf("Set %s field in %s hash to '%s'", OTAStatusField, OTAHashKey, status)
 
 	// Set component-specific status field using the configured component
 	if c.component != "" {
 		componentStatusField := fmt.Sprintf("status:%s", c.component)
-		if err := c.client.HSet(ctx, OTAHashKey, componentStatusField, status).Err(); err != nil {
-			log.Printf("Warning: Failed to set component status %s: %v", componentStatusField, err)
+		if err := c.client.HSet(ctx, c.hashKey, componentStatusField, status).Err(); err != nil {
+			logging.Warnf("Failed to set component status %s: %v", componentStatusField, err)
 		} else {
-			log.Printf("Set %s field in %s hash to '%s'", componentStatusField, OTAHashKey, status)
+			logging.Debugf("Set %s field in %s hash to '%s'", componentStatusField, c.hashKey, status)
 		}
 	}
 
 	// Publish the status update
-	publishErr := c.client.Publish(ctx, OTAHashKey, OTAStatusField).Err()
+	publishErr := c.client.Publish(ctx, c.hashKey, c.publishMessage(OTAStatusField, status)).Err()
 	if publishErr != nil {
-		log.Printf("Failed to publish status update for field %s: %v", OTAStatusField, publishErr)
+		logging.Warnf("Failed to publish status update for field %s: %v", OTAStatusField, publishErr)
 	} else {
-		log.Printf("Published status update for field %s", OTAStatusField)
+		logging.Debugf("Published status update for field %s", OTAStatusField)
 	}
 
 	return nil
 }
 
-
 // SetUpdateType sets the update-type field in the ota hash in Redis
 func (c *Client) SetUpdateType(ctx context.Context, updateType string) error {
-	err := c.client.HSet(ctx, OTAHashKey, OTAUpdateTypeField, updateType).Err()
+	err := c.client.HSet(ctx, c.hashKey, OTAUpdateTypeField, updateType).Err()
 	if err != nil {
-		return fmt.Errorf("failed to set %s field in %s hash in Redis: %w", OTAUpdateTypeField, OTAHashKey, err)
+		return fmt.Errorf("failed to set %s field in %s hash in Redis: %w", OTAUpdateTypeField, c.hashKey, err)
 	}
-	log.Printf("Set %s field in %s hash to '%s'", OTAUpdateTypeField, OTAHashKey, updateType)
+	logging.Debugf("Set %s field in %s hash to '%s'", OTAUpdateTypeField, c.hashKey, updateType)
 
 	// Publish the update type update
-	publishErr := c.client.Publish(ctx, OTAHashKey, OTAUpdateTypeField).Err()
+	publishErr := c.client.Publish(ctx, c.hashKey, c.publishMessage(OTAUpdateTypeField, updateType)).Err()
 	if publishErr != nil {
-		log.Printf("Failed to publish update type update for field %s: %v", OTAUpdateTypeField, publishErr)
+		logging.Warnf("Failed to publish update type update for field %s: %v", OTAUpdateTypeField, publishErr)
 	} else {
-		log.Printf("Published update type update for field %s", OTAUpdateTypeField)
+		logging.Debugf("Published update type update for field %s", OTAUpdateTypeField)
 	}
 
 	return nil
 }
 
+// Options configures how the Redis client connects
+type Options struct {
+	Addr string
+	DB   int
+
+	// Username and Password authenticate with AUTH, Username is only needed
+	// for Redis 6 ACL users
+	Username string
+	Password string
+
+	// TLS enables TLS to the server, verified against the system roots or
+	// the PEM bundle in CACert unless TLSSkipVerify is set
+	TLS           bool
+	CACert        string
+	TLSSkipVerify bool
+
+	// ExpectID, if set, must match the value stored under IdentityKey on the
+	// server, guarding against connecting to the wrong Redis instance
+	ExpectID    string
+	IdentityKey string
+
+	// ConnectAttempts and ConnectTimeout bound how long the initial ping is
+	// retried while the server is not up yet. Below 2 attempts, NewClient
+	// fails on the first error. A zero timeout only bounds the attempts.
+	ConnectAttempts int
+	ConnectTimeout  time.Duration
+}
+
 // NewClient creates a new Redis client
-func NewClient(ctx context.Context, addr string) (*Client, error) {
+func NewClient(ctx context.Context, opts Options) (*Client, error) {
+	var tlsConfig *tls.Config
+	if opts.TLS {
+		var err error
+		tlsConfig, err = newTLSConfig(opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	client := redis.NewClient(&redis.Options{
-		Addr: addr,
+		Addr:      opts.Addr,
+		DB:        opts.DB,
+		Username:  opts.Username,
+		Password:  opts.Password,
+		TLSConfig: tlsConfig,
 	})
 
-	_, err := client.Ping(ctx).Result()
-	if err != nil {
+	if err := connect(ctx, client, opts.ConnectAttempts, opts.ConnectTimeout); err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
+	if opts.ExpectID != "" {
+		if err := verifyIdentity(ctx, client, opts.IdentityKey, opts.ExpectID); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
 	return &Client{
-		client: client,
-		updateKey: "", // Will be set by SetUpdateKey
-		component: "", // Will be set by SetComponent
+		client:         client,
+		updateKey:      "", // Will be set by SetUpdateKey
+		component:      "", // Will be set by SetComponent
+		hashKey:        OTAHashKey,
+		maxEntryLength: DefaultMaxEntryLength,
+		blpopTimeout:   DefaultBLPopTimeout,
+		publishPayload: PublishField,
+		expectID:       opts.ExpectID,
+		identityKey:    opts.IdentityKey,
 	}, nil
 }
 
+// newTLSConfig builds the TLS configuration for the connection to the server
+func newTLSConfig(opts Options) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(opts.Addr)
+	if err != nil {
+		host = opts.Addr
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: opts.TLSSkipVerify,
+	}
+
+	if opts.CACert != "" {
+		pem, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in redis CA file %s", opts.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// verifyIdentity checks that the identity key on the server holds the expected ID
+func verifyIdentity(ctx context.Context, client *redis.Client, key, expectID string) error {
+	id, err := client.Get(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return fmt.Errorf("redis identity key %s is not set, expected '%s'", key, expectID)
+		}
+		return fmt.Errorf("failed to read redis identity key %s: %w", key, err)
+	}
+	if id != expectID {
+		return fmt.Errorf("connected to unexpected redis instance: %s is '%s', expected '%s'", key, id, expectID)
+	}
+	logging.Infof("Verified redis identity '%s'", id)
+	return nil
+}
+
 // SetUpdateKey sets the update key for the client
 func (c *Client) SetUpdateKey(updateKey string) {
 	c.updateKey = updateKey
-	log.Printf("Set update key to: %s", updateKey)
+	logging.Debugf("Set update key to: %s", updateKey)
 }
 
 // SetComponent sets the component for the client
 func (c *Client) SetComponent(component string) {
 	c.component = component
-	log.Printf("Set component to: %s", component)
+	logging.Debugf("Set component to: %s", component)
+}
+
+// SetHashKey sets the hash key status fields are written to and published on,
+// e.g. a per-component "ota:dbc" so several components can share one Redis
+func (c *Client) SetHashKey(hashKey string) {
+	c.hashKey = hashKey
+	logging.Debugf("Set OTA hash key to: %s", hashKey)
+}
+
+// DefaultBLPopTimeout is how long a single BLPOP blocks before WaitForUpdate
+// checks for cancellation and polls again
+const DefaultBLPopTimeout = 5 * time.Second
+
+// SetBLPopTimeout sets how long a single BLPOP in WaitForUpdate blocks
+func (c *Client) SetBLPopTimeout(timeout time.Duration) {
+	c.blpopTimeout = timeout
+}
+
+// Clone returns a copy of the client sharing its connection, so several
+// components can be configured independently on a single connection. Only
+// the original client should be closed.
+func (c *Client) Clone() *Client {
+	clone := *c
+	return &clone
+}
+
+// SetStatusHook sets a function that is called with the component and every status that is set
+func (c *Client) SetStatusHook(fn func(component, status string)) {
+	c.onStatus = fn
+}
+
+// Ping checks that the Redis server is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+// SetMaxEntryLength sets the maximum accepted length of an update list entry
+func (c *Client) SetMaxEntryLength(maxEntryLength int) {
+	c.maxEntryLength = maxEntryLength
 }
 
 // Close closes the Redis client
@@ -108,67 +299,172 @@ func (c *Client) Close() error {
 	return c.client.Close()
 }
 
-// WaitForUpdate waits for an update URL using BLPOP and keeps popping until the list is empty
-func (c *Client) WaitForUpdate(ctx context.Context, updateKey string, checksumKey string) (string, string, error) {
-	log.Printf("Waiting for update on key: %s", updateKey)
+// WaitForUpdate waits for an update entry using BLPOP and keeps popping until
+// the list is empty. The entry with the highest priority is selected. Entries
+// of lower priority are pushed back onto the list in their original order, to
+// be taken by a later call; entries of the same priority that were drained
+// before the selected one are superseded by it and dropped. Unless the
+// selected entry carries a checksum, it is read from checksumKey.
+func (c *Client) WaitForUpdate(ctx context.Context, updateKey string, checksumKey string) (UpdateRequest, error) {
+	logging.Infof("Waiting for update on key: %s", updateKey)
 
 	// Store the update key
 	c.updateKey = updateKey
-	
-	// First BLPOP to wait for at least one entry
-	result, err := c.client.BLPop(ctx, 0, updateKey).Result()
+
+	// Parse the drained entries, keeping the raw form of each to push it back
+	var entries []UpdateRequest
+	var kept []string
+	for len(entries) == 0 {
+		raws, err := c.drainUpdates(ctx, updateKey)
+		if err != nil {
+			return UpdateRequest{}, err
+		}
+
+		malformed := 0
+		for _, raw := range raws {
+			if isBlankEntry(raw) {
+				logging.Debugf("Skipping empty update entry")
+				continue
+			}
+			entry, err := parseUpdateRequest(raw, c.maxEntryLength)
+			if err != nil {
+				malformed++
+				logging.Warnf("Skipping malformed update entry (%d bytes): %v", len(raw), err)
+				continue
+			}
+			entries = append(entries, entry)
+			kept = append(kept, raw)
+		}
+
+		if len(entries) == 0 {
+			if malformed > 0 {
+				return UpdateRequest{}, fmt.Errorf("%w: all %d entries rejected", ErrMalformedEntry, malformed)
+			}
+			// Only empty entries were pushed, keep waiting for a real one
+			logging.Infof("Update list held only empty entries, waiting again")
+		}
+	}
+
+	// Pick the highest-priority entry, ties go to the last one drained
+	selected := selectUpdateRequest(entries)
+	logging.Infof("Using final URL from list: %s (priority %d)", logging.RedactURL(selected.URL), selected.Priority)
+
+	var deferred []string
+	for i, entry := range entries {
+		if entry.Priority < selected.Priority {
+			deferred = append(deferred, kept[i])
+		}
+	}
+	c.pushBackUpdates(ctx, updateKey, deferred)
+
+	if selected.Checksum == "" && checksumKey != "" {
+		checksum, err := c.client.Get(ctx, checksumKey).Result()
+		if err != nil && err != redis.Nil {
+			return UpdateRequest{}, fmt.Errorf("failed to get checksum from key %s: %w", checksumKey, err)
+		}
+		if err != redis.Nil && checksum != "" {
+			logging.Infof("Found checksum: %s", checksum)
+		}
+		selected.Checksum = checksum
+	}
+
+	return selected, nil
+}
+
+// drainUpdates blocks until the update list holds an entry, reconnecting if
+// the server goes away, and then pops all entries in list order
+func (c *Client) drainUpdates(ctx context.Context, updateKey string) ([]string, error) {
+	// A finite BLPOP timeout keeps shutdown responsive even if a blocked
+	// BLPOP does not observe context cancellation
+	var result []string
+	var err error
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result, err = c.client.BLPop(ctx, c.blpopTimeout, updateKey).Result()
+		if err == redis.Nil {
+			// Timed out without an entry, poll again
+			continue
+		}
+		if !IsConnectionError(err) {
+			break
+		}
+		logging.Warnf("Lost connection to Redis while waiting for update: %v", err)
+		if err := c.Reconnect(ctx); err != nil {
+			return nil, err
+		}
+	}
 	if err != nil {
 		if err == context.Canceled {
-			return "", "", err
+			return nil, err
 		}
-		return "", "", fmt.Errorf("failed to BLPOP from key %s: %w", updateKey, err)
+		return nil, fmt.Errorf("failed to BLPOP from key %s: %w", updateKey, err)
 	}
-
 	if len(result) != 2 {
-		return "", "", fmt.Errorf("unexpected result from BLPOP: %v", result)
+		return nil, fmt.Errorf("unexpected result from BLPOP: %v", result)
 	}
 
-	// Get the first URL
-	lastUrl := result[1]
-	if lastUrl == "" {
-		return "", "", fmt.Errorf("received empty URL")
-	}
-	
-	// Keep popping until the list is empty
+	raws := []string{result[1]}
 	for {
 		// Use LPOP (non-blocking) to check if there are more entries
-		result, err := c.client.LPop(ctx, updateKey).Result()
+		raw, err := c.client.LPop(ctx, updateKey).Result()
 		if err != nil {
-			if err == redis.Nil {
-				// List is empty, we're done
-				break
+			if err != redis.Nil {
+				// Log other errors but continue with the entries we got
+				logging.Warnf("Error during LPOP from key %s: %v", updateKey, err)
 			}
-			// Log other errors but continue with the last URL we got
-			log.Printf("Warning: Error during LPOP from key %s: %v", updateKey, err)
-			break
-		}
-		
-		// If we got a non-empty URL, update our lastUrl
-		if result != "" {
-			log.Printf("Found additional URL in list, using: %s", result)
-			lastUrl = result
+			return raws, nil
 		}
+		logging.Debugf("Found additional entry in list (%d bytes)", len(raw))
+		raws = append(raws, raw)
 	}
-	
-	log.Printf("Using final URL from list: %s", lastUrl)
+}
 
-	checksum := ""
-	if checksumKey != "" {
-		checksum, err := c.client.Get(ctx, checksumKey).Result()
-		if err != nil && err != redis.Nil {
-			return "", "", fmt.Errorf("failed to get checksum from key %s: %w", checksumKey, err)
+// pushBackUpdates puts raw entries back at the head of the update list in
+// their original order
+func (c *Client) pushBackUpdates(ctx context.Context, updateKey string, raws []string) {
+	if len(raws) == 0 {
+		return
+	}
+	// LPUSH prepends its values one by one, so they go in reverse
+	values := make([]interface{}, len(raws))
+	for i, raw := range raws {
+		values[len(raws)-1-i] = raw
+	}
+	if err := c.client.LPush(ctx, updateKey, values...).Err(); err != nil {
+		logging.Errorf("Failed to push %d lower-priority entries back onto %s: %v", len(raws), updateKey, err)
+		return
+	}
+	logging.Infof("Pushed %d lower-priority entries back onto %s", len(raws), updateKey)
+}
+
+// PeekUpdate returns the entry WaitForUpdate would pick from the update list
+// and the number of entries queued, without removing any. The entry is nil
+// when no valid one is queued.
+func (c *Client) PeekUpdate(ctx context.Context, updateKey string) (*UpdateRequest, int, error) {
+	raws, err := c.client.LRange(ctx, updateKey, 0, -1).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to LRANGE key %s: %w", updateKey, err)
+	}
+
+	var entries []UpdateRequest
+	for _, raw := range raws {
+		if isBlankEntry(raw) {
+			continue
 		}
-		if err != redis.Nil && checksum != "" {
-			log.Printf("Found checksum: %s", checksum)
+		entry, err := parseUpdateRequest(raw, c.maxEntryLength)
+		if err != nil {
+			logging.Warnf("Ignoring malformed update entry (%d bytes): %v", len(raw), err)
+			continue
 		}
+		entries = append(entries, entry)
 	}
-
-	return lastUrl, checksum, nil
+	if len(entries) == 0 {
+		return nil, len(raws), nil
+	}
+	selected := selectUpdateRequest(entries)
+	return &selected, len(raws), nil
 }
 
 // GetChecksum gets the checksum from Redis
@@ -183,12 +479,189 @@ func (c *Client) GetChecksum(ctx context.Context, key string) (string, error) {
 	return checksum, nil
 }
 
-// SetFailure sets the failure key in Redis
-func (c *Client) SetFailure(ctx context.Context, key, message string) error {
-	err := c.client.Set(ctx, key, message, 0).Err()
+// RequeueUpdate pushes an update entry back onto the head of the update list,
+// so it is taken again by the next WaitForUpdate
+func (c *Client) RequeueUpdate(ctx context.Context, updateKey string, update UpdateRequest) error {
+	if err := c.client.LPush(ctx, updateKey, update.Encode()).Err(); err != nil {
+		return fmt.Errorf("failed to LPUSH to key %s: %w", updateKey, err)
+	}
+	return nil
+}
+
+// Failure is the structured value written to the failure key
+type Failure struct {
+	Phase     string    `json:"phase"` // download, verify, install, health-check or unknown
+	Message   string    `json:"message"`
+	Stderr    string    `json:"stderr,omitempty"` // output of a failed mender-update install
+	Stdout    string    `json:"stdout,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// SetFailure sets the failure key in Redis to the failure as JSON
+func (c *Client) SetFailure(ctx context.Context, key string, failure Failure) error {
+	data, err := json.Marshal(failure)
+	if err != nil {
+		return fmt.Errorf("failed to encode failure: %w", err)
+	}
+	err = c.client.Set(ctx, key, data, 0).Err()
 	if err != nil {
 		return fmt.Errorf("failed to set failure key in Redis: %w", err)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// SetLastDownloadDuration sets the duration of the last successful download in the ota hash in Redis
+func (c *Client) SetLastDownloadDuration(ctx context.Context, d time.Duration) error {
+	return c.setSeconds(ctx, OTALastDownloadSecondsField, d)
+}
+
+// SetLastInstallDuration sets the duration of the last successful install in the ota hash in Redis
+func (c *Client) SetLastInstallDuration(ctx context.Context, d time.Duration) error {
+	return c.setSeconds(ctx, OTALastInstallSecondsField, d)
+}
+
+// setSeconds sets a field in the ota hash to a duration in seconds
+func (c *Client) setSeconds(ctx context.Context, field string, d time.Duration) error {
+	seconds := strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+	if err := c.client.HSet(ctx, c.hashKey, field, seconds).Err(); err != nil {
+		return fmt.Errorf("failed to set %s field in %s hash in Redis: %w", field, c.hashKey, err)
+	}
+	logging.Debugf("Set %s field in %s hash to %s", field, c.hashKey, seconds)
+	return nil
+}
+
+// SetDownloadAttempt sets the download retry attempt fields in the ota hash in Redis
+func (c *Client) SetDownloadAttempt(ctx context.Context, attempt, maxAttempts int) error {
+	err := c.client.HSet(ctx, c.hashKey, OTADownloadAttemptField, attempt, OTADownloadMaxAttemptsField, maxAttempts).Err()
+	if err != nil {
+		return fmt.Errorf("failed to set download attempt in %s hash in Redis: %w", c.hashKey, err)
+	}
+	logging.Debugf("Set %s field in %s hash to %d/%d", OTADownloadAttemptField, c.hashKey, attempt, maxAttempts)
+	return nil
+}
+
+// ClearDownloadAttempt removes the download retry attempt fields from the ota hash in Redis
+func (c *Client) ClearDownloadAttempt(ctx context.Context) error {
+	err := c.client.HDel(ctx, c.hashKey, OTADownloadAttemptField, OTADownloadMaxAttemptsField).Err()
+	if err != nil {
+		return fmt.Errorf("failed to clear download attempt in %s hash in Redis: %w", c.hashKey, err)
+	}
+	return nil
+}
+
+// SetInstallProgress sets the install-progress field (percent) in the ota hash in Redis
+func (c *Client) SetInstallProgress(ctx context.Context, percent int) error {
+	err := c.client.HSet(ctx, c.hashKey, OTAInstallProgressField, percent).Err()
+	if err != nil {
+		return fmt.Errorf("failed to set %s field in %s hash in Redis: %w", OTAInstallProgressField, c.hashKey, err)
+	}
+	return nil
+}
+
+// SetLastUpdate records the last successfully installed update in the ota hash in Redis.
+// The fingerprint identifies the update request, see GetLastFingerprint.
+func (c *Client) SetLastUpdate(ctx context.Context, version, url, fingerprint string, ts time.Time) error {
+	err := c.client.HSet(ctx, c.hashKey,
+		OTALastVersionField, version,
+		OTALastURLField, url,
+		OTALastFingerprintField, fingerprint,
+		OTALastTimestampField, ts.UTC().Format(time.RFC3339),
+	).Err()
+	if err != nil {
+		return fmt.Errorf("failed to set last update in %s hash in Redis: %w", c.hashKey, err)
+	}
+	logging.Debugf("Set %s field in %s hash to '%s'", OTALastVersionField, c.hashKey, version)
+	return nil
+}
+
+// SetArtifactVersion sets the version parsed from the filename of the
+// downloaded artifact in the ota hash in Redis, "" if it has none
+func (c *Client) SetArtifactVersion(ctx context.Context, version string) error {
+	err := c.client.HSet(ctx, c.hashKey, OTAArtifactVersionField, version).Err()
+	if err != nil {
+		return fmt.Errorf("failed to set %s field in %s hash in Redis: %w", OTAArtifactVersionField, c.hashKey, err)
+	}
+	logging.Debugf("Set %s field in %s hash to '%s'", OTAArtifactVersionField, c.hashKey, version)
+	return nil
+}
+
+// SetPendingArtifact records the name, device types and payload types of the
+// artifact about to be installed in the ota hash in Redis
+func (c *Client) SetPendingArtifact(ctx context.Context, name string, deviceTypes, payloadTypes []string) error {
+	err := c.client.HSet(ctx, c.hashKey,
+		OTAPendingArtifactNameField, name,
+		OTAPendingDeviceTypesField, strings.Join(deviceTypes, ","),
+		OTAPendingPayloadTypeField, strings.Join(payloadTypes, ","),
+	).Err()
+	if err != nil {
+		return fmt.Errorf("failed to set pending artifact in %s hash in Redis: %w", c.hashKey, err)
+	}
+	logging.Debugf("Set %s field in %s hash to '%s'", OTAPendingArtifactNameField, c.hashKey, name)
+	return nil
+}
+
+// GetPendingArtifactName gets the name of the artifact whose install was
+// started but did not return from the ota hash in Redis, or "" if none
+func (c *Client) GetPendingArtifactName(ctx context.Context) (string, error) {
+	name, err := c.client.HGet(ctx, c.hashKey, OTAPendingArtifactNameField).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get %s field from %s hash in Redis: %w", OTAPendingArtifactNameField, c.hashKey, err)
+	}
+	return name, nil
+}
+
+// ClearPendingArtifact removes the pending artifact fields from the ota hash in Redis
+func (c *Client) ClearPendingArtifact(ctx context.Context) error {
+	err := c.client.HDel(ctx, c.hashKey, OTAPendingArtifactNameField, OTAPendingDeviceTypesField, OTAPendingPayloadTypeField).Err()
+	if err != nil {
+		return fmt.Errorf("failed to clear pending artifact in %s hash in Redis: %w", c.hashKey, err)
+	}
+	return nil
+}
+
+// GetLastFingerprint gets the fingerprint of the last successfully installed
+// update from the ota hash in Redis, or "" if none was recorded
+func (c *Client) GetLastFingerprint(ctx context.Context) (string, error) {
+	fingerprint, err := c.client.HGet(ctx, c.hashKey, OTALastFingerprintField).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get %s field from %s hash in Redis: %w", OTALastFingerprintField, c.hashKey, err)
+	}
+	return fingerprint, nil
+}
+
+// GetOTAFields gets all fields of the ota hash in Redis
+func (c *Client) GetOTAFields(ctx context.Context) (map[string]string, error) {
+	fields, err := c.client.HGetAll(ctx, c.hashKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s hash from Redis: %w", c.hashKey, err)
+	}
+	return fields, nil
+}
+
+// GetFailure gets the value of the failure key in Redis, or "" if it is not set
+func (c *Client) GetFailure(ctx context.Context, key string) (string, error) {
+	failure, err := c.client.Get(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get failure key from Redis: %w", err)
+	}
+	return failure, nil
+}
+
+// SetDownloadProgress sets the download-progress field (percent) in the ota hash in Redis
+func (c *Client) SetDownloadProgress(ctx context.Context, percent int) error {
+	err := c.client.HSet(ctx, c.hashKey, OTADownloadProgressField, percent).Err()
+	if err != nil {
+		return fmt.Errorf("failed to set %s field in %s hash in Redis: %w", OTADownloadProgressField, c.hashKey, err)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package redis
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifyIdentity(t *testing.T) {
+	s := newFakeServer(t)
+	s.setString("identity", "scooter-1")
+
+	if _, err := NewClient(context.Background(), Options{Addr: s.addr, ExpectID: "scooter-2", IdentityKey: "identity"}); err == nil {
+		t.Error("NewClient() connected to the wrong instance")
+	}
+	c, err := NewClient(context.Background(), Options{Addr: s.addr, ExpectID: "scooter-1", IdentityKey: "identity"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	c.Close()
+}
+
+func TestNewClientSelectsDatabase(t *testing.T) {
+	s := newFakeServer(t)
+	c, err := NewClient(context.Background(), Options{Addr: s.addr, DB: 3})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer c.Close()
+
+	if err := c.SetStatus(context.Background(), "ready"); err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+	if dbs := s.databases(); len(dbs) == 0 || dbs[len(dbs)-1] != "3" {
+		t.Errorf("databases selected = %v, want 3", dbs)
+	}
+}
+
+// serveTLS restarts s behind TLS with a self-signed certificate for
+// 127.0.0.1 and returns the path of the certificate in PEM form
+func serveTLS(t *testing.T, s *fakeServer) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s.stop()
+	ln, err := tls.Listen("tcp", s.addr, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s.serveOn(ln)
+	return caPath
+}
+
+func TestNewClientTLS(t *testing.T) {
+	s := newFakeServer(t)
+	caPath := serveTLS(t, s)
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "trusted CA", opts: Options{TLS: true, CACert: caPath}},
+		{name: "verification skipped", opts: Options{TLS: true, TLSSkipVerify: true}},
+		{name: "unknown CA", opts: Options{TLS: true}, wantErr: true},
+		{name: "plain TCP", opts: Options{}, wantErr: true},
+		{name: "missing CA file", opts: Options{TLS: true, CACert: filepath.Join(t.TempDir(), "none.pem")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Addr = s.addr
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			c, err := NewClient(ctx, tt.opts)
+			if err == nil {
+				c.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	c.SetComponent("dbc")
+	c.SetHashKey("ota:dbc")
+	var hooked []string
+	c.SetStatusHook(func(component, status string) {
+		hooked = append(hooked, component+"/"+status)
+	})
+
+	if err := c.SetStatus(context.Background(), "downloading-updates"); err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+
+	fields := s.hash("ota:dbc")
+	if fields[OTAStatusField] != "downloading-updates" || fields["status:dbc"] != "downloading-updates" {
+		t.Errorf("ota:dbc hash = %v", fields)
+	}
+	if len(hooked) != 1 || hooked[0] != "dbc/downloading-updates" {
+		t.Errorf("status hook called with %v", hooked)
+	}
+}
+
+func TestLastUpdateFingerprint(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	ctx := context.Background()
+
+	if fingerprint, err := c.GetLastFingerprint(ctx); err != nil || fingerprint != "" {
+		t.Fatalf("GetLastFingerprint() before any update = %q, %v", fingerprint, err)
+	}
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := c.SetLastUpdate(ctx, "v2", "https://example.com/v2.mender", "abc", ts); err != nil {
+		t.Fatalf("SetLastUpdate() error = %v", err)
+	}
+	if fingerprint, err := c.GetLastFingerprint(ctx); err != nil || fingerprint != "abc" {
+		t.Errorf("GetLastFingerprint() = %q, %v, want abc", fingerprint, err)
+	}
+	fields := s.hash(OTAHashKey)
+	if fields[OTALastVersionField] != "v2" || fields[OTALastTimestampField] != "2024-05-01T12:00:00Z" {
+		t.Errorf("ota hash = %v", fields)
+	}
+}
+
+func TestPublishJSON(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	c.SetComponent("mdb")
+	if err := c.SetPublishPayload(PublishJSON); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetPublishPayload("xml"); err == nil {
+		t.Error("SetPublishPayload(xml) succeeded")
+	}
+
+	var event statusEvent
+	if err := json.Unmarshal([]byte(c.publishMessage(OTAStatusField, "installing-updates")), &event); err != nil {
+		t.Fatalf("publishMessage() is not JSON: %v", err)
+	}
+	if event.Field != OTAStatusField || event.Value != "installing-updates" || event.Component != "mdb" || event.Timestamp == 0 {
+		t.Errorf("publishMessage() = %+v", event)
+	}
+}
+
+func TestSetFailure(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	failure := Failure{Phase: "install", Message: "exit status 1", Stderr: "boom", Timestamp: time.Now().UTC().Truncate(time.Second)}
+	if err := c.SetFailure(context.Background(), "failure", failure); err != nil {
+		t.Fatalf("SetFailure() error = %v", err)
+	}
+
+	raw, err := c.GetFailure(context.Background(), "failure")
+	if err != nil {
+		t.Fatalf("GetFailure() error = %v", err)
+	}
+	var got Failure
+	if err := json.Unmarshal([]byte(raw), &got); err != nil || got != failure {
+		t.Errorf("failure = %+v, %v, want %+v", got, err, failure)
+	}
+}
+
+func TestPendingArtifact(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s)
+	ctx := context.Background()
+
+	if err := c.SetPendingArtifact(ctx, "v2", []string{"a", "b"}, []string{"rootfs-image"}); err != nil {
+		t.Fatalf("SetPendingArtifact() error = %v", err)
+	}
+	if name, err := c.GetPendingArtifactName(ctx); err != nil || name != "v2" {
+		t.Errorf("GetPendingArtifactName() = %q, %v, want v2", name, err)
+	}
+	if got := s.hash(OTAHashKey)[OTAPendingDeviceTypesField]; got != "a,b" {
+		t.Errorf("%s = %q, want a,b", OTAPendingDeviceTypesField, got)
+	}
+
+	if err := c.ClearPendingArtifact(ctx); err != nil {
+		t.Fatalf("ClearPendingArtifact() error = %v", err)
+	}
+	if name, err := c.GetPendingArtifactName(ctx); err != nil || name != "" {
+		t.Errorf("GetPendingArtifactName() after clear = %q, %v", name, err)
+	}
+}
@@ -0,0 +1,524 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is an in-memory server speaking enough of the Redis protocol
+// for the commands Client sends. Its data outlives restarts, so a test can
+// stop it to simulate an outage and start it again on the same address.
+type fakeServer struct {
+	t    *testing.T
+	addr string
+
+	mu      sync.Mutex
+	ln      net.Listener
+	conns   map[net.Conn]bool
+	strs    map[string]string
+	lists   map[string][]string
+	hashes  map[string]map[string]string
+	subs    map[string]map[*fakeConn]bool
+	running bool
+
+	// selected lists the databases picked with SELECT
+	selected []string
+}
+
+// fakeConn is a client connection to a fakeServer
+type fakeConn struct {
+	conn net.Conn
+	w    *bufio.Writer
+	wmu  sync.Mutex
+}
+
+// status is a simple string reply such as +OK
+type status string
+
+// nilArray is the null array reply of a timed out BLPOP
+type nilArray struct{}
+
+// newFakeServer starts a server on a free local port, stopped with the test
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{
+		t:      t,
+		strs:   make(map[string]string),
+		lists:  make(map[string][]string),
+		hashes: make(map[string]map[string]string),
+		subs:   make(map[string]map[*fakeConn]bool),
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s.addr = ln.Addr().String()
+	s.serveOn(ln)
+	t.Cleanup(s.stop)
+	return s
+}
+
+// newTestClient connects a Client to s
+func newTestClient(t *testing.T, s *fakeServer) *Client {
+	c, err := NewClient(context.Background(), Options{Addr: s.addr})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// serveOn accepts connections on ln until the server is stopped
+func (s *fakeServer) serveOn(ln net.Listener) {
+	s.mu.Lock()
+	s.ln = ln
+	s.conns = make(map[net.Conn]bool)
+	s.running = true
+	s.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns[conn] = true
+			s.mu.Unlock()
+			go s.serve(&fakeConn{conn: conn, w: bufio.NewWriter(conn)})
+		}
+	}()
+}
+
+// restart starts a stopped server again on its previous address
+func (s *fakeServer) restart() {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		s.t.Errorf("listen: %v", err)
+		return
+	}
+	s.serveOn(ln)
+}
+
+// stop closes the listener and drops all connections
+func (s *fakeServer) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return
+	}
+	s.running = false
+	s.ln.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.subs = make(map[string]map[*fakeConn]bool)
+}
+
+func (s *fakeServer) serve(c *fakeConn) {
+	defer func() {
+		c.conn.Close()
+		s.mu.Lock()
+		delete(s.conns, c.conn)
+		for _, subs := range s.subs {
+			delete(subs, c)
+		}
+		s.mu.Unlock()
+	}()
+
+	r := bufio.NewReader(c.conn)
+	var queued [][]string
+	inTx := false
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		name := strings.ToUpper(args[0])
+		switch {
+		case name == "MULTI":
+			inTx = true
+			c.write(status("OK"))
+		case name == "EXEC":
+			replies := make([]interface{}, 0, len(queued))
+			for _, cmd := range queued {
+				replies = append(replies, s.exec(c, cmd))
+			}
+			queued, inTx = nil, false
+			c.write(replies)
+		case inTx:
+			queued = append(queued, args)
+			c.write(status("QUEUED"))
+		default:
+			c.write(s.exec(c, args))
+		}
+	}
+}
+
+// exec runs one command and returns its reply
+func (s *fakeServer) exec(c *fakeConn, args []string) interface{} {
+	name := strings.ToUpper(args[0])
+	if name == "BLPOP" {
+		return s.blpop(args)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch name {
+	case "PING":
+		if len(s.subsOf(c)) > 0 {
+			return []interface{}{"pong", ""}
+		}
+		return status("PONG")
+	case "SELECT":
+		s.selected = append(s.selected, args[1])
+		return status("OK")
+	case "AUTH":
+		return status("OK")
+	case "GET":
+		if v, ok := s.strs[args[1]]; ok {
+			return v
+		}
+		return nil
+	case "SET":
+		return s.set(args)
+	case "DEL":
+		n := int64(0)
+		for _, key := range args[1:] {
+			if s.del(key) {
+				n++
+			}
+		}
+		return n
+	case "HSET":
+		h := s.hashes[args[1]]
+		if h == nil {
+			h = make(map[string]string)
+			s.hashes[args[1]] = h
+		}
+		n := int64(0)
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				n++
+			}
+			h[args[i]] = args[i+1]
+		}
+		return n
+	case "HGET":
+		if v, ok := s.hashes[args[1]][args[2]]; ok {
+			return v
+		}
+		return nil
+	case "HGETALL":
+		var reply []interface{}
+		for k, v := range s.hashes[args[1]] {
+			reply = append(reply, k, v)
+		}
+		return reply
+	case "HDEL":
+		n := int64(0)
+		for _, field := range args[2:] {
+			if _, ok := s.hashes[args[1]][field]; ok {
+				delete(s.hashes[args[1]], field)
+				n++
+			}
+		}
+		return n
+	case "LPUSH":
+		for _, v := range args[2:] {
+			s.lists[args[1]] = append([]string{v}, s.lists[args[1]]...)
+		}
+		return int64(len(s.lists[args[1]]))
+	case "RPUSH":
+		s.lists[args[1]] = append(s.lists[args[1]], args[2:]...)
+		return int64(len(s.lists[args[1]]))
+	case "LPOP":
+		if v, ok := s.lpop(args[1]); ok {
+			return v
+		}
+		return nil
+	case "LRANGE":
+		start, _ := strconv.Atoi(args[2])
+		stop, _ := strconv.Atoi(args[3])
+		reply := []interface{}{}
+		for _, v := range s.lrange(args[1], start, stop) {
+			reply = append(reply, v)
+		}
+		return reply
+	case "LTRIM":
+		start, _ := strconv.Atoi(args[2])
+		stop, _ := strconv.Atoi(args[3])
+		s.lists[args[1]] = s.lrange(args[1], start, stop)
+		return status("OK")
+	case "PUBLISH":
+		return int64(s.publishLocked(args[1], args[2]))
+	case "SUBSCRIBE":
+		for _, channel := range args[1:] {
+			if s.subs[channel] == nil {
+				s.subs[channel] = make(map[*fakeConn]bool)
+			}
+			s.subs[channel][c] = true
+			c.write([]interface{}{"subscribe", channel, int64(len(s.subsOf(c)))})
+		}
+		return noReply{}
+	case "UNSUBSCRIBE":
+		for _, channel := range args[1:] {
+			delete(s.subs[channel], c)
+			c.write([]interface{}{"unsubscribe", channel, int64(len(s.subsOf(c)))})
+		}
+		return noReply{}
+	case "EVALSHA":
+		return errors.New("NOSCRIPT No matching script")
+	case "EVAL":
+		return s.eval(args)
+	}
+	return fmt.Errorf("ERR unknown command '%s'", args[0])
+}
+
+// noReply marks commands that wrote their replies themselves
+type noReply struct{}
+
+func (s *fakeServer) set(args []string) interface{} {
+	key, value := args[1], args[2]
+	nx := false
+	for _, opt := range args[3:] {
+		if strings.EqualFold(opt, "NX") {
+			nx = true
+		}
+	}
+	if _, ok := s.strs[key]; ok && nx {
+		return nil
+	}
+	s.strs[key] = value
+	return status("OK")
+}
+
+func (s *fakeServer) del(key string) bool {
+	_, str := s.strs[key]
+	_, list := s.lists[key]
+	_, hash := s.hashes[key]
+	delete(s.strs, key)
+	delete(s.lists, key)
+	delete(s.hashes, key)
+	return str || list || hash
+}
+
+func (s *fakeServer) lpop(key string) (string, bool) {
+	list := s.lists[key]
+	if len(list) == 0 {
+		return "", false
+	}
+	s.lists[key] = list[1:]
+	return list[0], true
+}
+
+func (s *fakeServer) lrange(key string, start, stop int) []string {
+	list := s.lists[key]
+	if start < 0 {
+		start += len(list)
+	}
+	if stop < 0 {
+		stop += len(list)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= len(list) {
+		stop = len(list) - 1
+	}
+	if start > stop {
+		return nil
+	}
+	return append([]string(nil), list[start:stop+1]...)
+}
+
+// blpop polls the list until an entry arrives, the timeout passes or the
+// server stops
+func (s *fakeServer) blpop(args []string) interface{} {
+	key := args[1]
+	seconds, _ := strconv.ParseFloat(args[len(args)-1], 64)
+	deadline := time.Now().Add(time.Duration(seconds * float64(time.Second)))
+	for {
+		s.mu.Lock()
+		v, ok := s.lpop(key)
+		running := s.running
+		s.mu.Unlock()
+		if ok {
+			return []interface{}{key, v}
+		}
+		if !running || (seconds > 0 && time.Now().After(deadline)) {
+			return nilArray{}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// eval runs the lock scripts, recognised by the command they end in
+func (s *fakeServer) eval(args []string) interface{} {
+	script, key, token := args[1], args[3], args[4]
+	if s.strs[key] != token {
+		return int64(0)
+	}
+	if strings.Contains(script, "DEL") {
+		delete(s.strs, key)
+	}
+	return int64(1)
+}
+
+func (s *fakeServer) subsOf(c *fakeConn) []string {
+	var channels []string
+	for channel, subs := range s.subs {
+		if subs[c] {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+func (s *fakeServer) publishLocked(channel, message string) int {
+	for c := range s.subs[channel] {
+		c.write([]interface{}{"message", channel, message})
+	}
+	return len(s.subs[channel])
+}
+
+// publish sends message to the subscribers of channel
+func (s *fakeServer) publish(channel, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publishLocked(channel, message)
+}
+
+// setString sets key like SET
+func (s *fakeServer) setString(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.strs[key] = value
+}
+
+// getString returns the string at key
+func (s *fakeServer) getString(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.strs[key]
+	return v, ok
+}
+
+// rpush appends values to the list at key like RPUSH
+func (s *fakeServer) rpush(key string, values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lists[key] = append(s.lists[key], values...)
+}
+
+// list returns a copy of the list at key
+func (s *fakeServer) list(key string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.lists[key]...)
+}
+
+// hash returns a copy of the hash at key
+func (s *fakeServer) hash(key string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := make(map[string]string)
+	for k, v := range s.hashes[key] {
+		h[k] = v
+	}
+	return h
+}
+
+// databases returns the databases clients picked with SELECT
+func (s *fakeServer) databases() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.selected...)
+}
+
+// subscribers returns the number of subscribers of channel
+func (s *fakeServer) subscribers(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs[channel])
+}
+
+// readCommand reads one command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// write sends reply to the client
+func (c *fakeConn) write(reply interface{}) {
+	if _, ok := reply.(noReply); ok {
+		return
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	writeReply(c.w, reply)
+	c.w.Flush()
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case nilArray:
+		w.WriteString("*-1\r\n")
+	case status:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case error:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		panic(fmt.Sprintf("unsupported reply %T", reply))
+	}
+}
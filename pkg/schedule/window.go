@@ -0,0 +1,102 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// maxWaitStep caps a single sleep while waiting for the window, so jumps of
+// the wall clock (e.g. a late NTP sync) are noticed
+const maxWaitStep = time.Minute
+
+// Window is a daily time window in local time. It may wrap around midnight,
+// e.g. 22:00-04:00.
+type Window struct {
+	start time.Duration // Offset of the start from midnight
+	end   time.Duration // Offset of the end from midnight
+
+	// now returns the current time, replaceable for testing
+	now func() time.Time
+}
+
+// ParseWindow parses a window from start and end times in HH:MM form
+func ParseWindow(start, end string) (*Window, error) {
+	s, err := parseClock(start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid window start: %w", err)
+	}
+	e, err := parseClock(end)
+	if err != nil {
+		return nil, fmt.Errorf("invalid window end: %w", err)
+	}
+	if s == e {
+		return nil, fmt.Errorf("window start and end must differ")
+	}
+	return &Window{start: s, end: e, now: time.Now}, nil
+}
+
+// parseClock parses HH:MM into an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not in HH:MM form", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// offset returns how far t is past local midnight
+func offset(t time.Time) time.Duration {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return t.Sub(midnight)
+}
+
+// Contains reports whether t falls inside the window
+func (w *Window) Contains(t time.Time) bool {
+	o := offset(t)
+	if w.start < w.end {
+		return o >= w.start && o < w.end
+	}
+	return o >= w.start || o < w.end
+}
+
+// UntilOpen returns how long it is from t until the window next opens, or
+// 0 if t is inside the window
+func (w *Window) UntilOpen(t time.Time) time.Duration {
+	if w.Contains(t) {
+		return 0
+	}
+	d := w.start - offset(t)
+	if d < 0 {
+		d += 24 * time.Hour
+	}
+	return d
+}
+
+// Wait blocks until the window is open or ctx is done
+func (w *Window) Wait(ctx context.Context) error {
+	for {
+		d := w.UntilOpen(w.now())
+		if d == 0 {
+			return nil
+		}
+		if d > maxWaitStep {
+			d = maxWaitStep
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// String formats the window as HH:MM-HH:MM
+func (w *Window) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return clock(w.start) + "-" + clock(w.end)
+}
@@ -0,0 +1,89 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2024, 5, 1, hour, minute, 0, 0, time.Local)
+}
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		start, end string
+		wantErr    bool
+	}{
+		{start: "02:00", end: "05:00"},
+		{start: "22:00", end: "04:00"},
+		{start: "noon", end: "05:00", wantErr: true},
+		{start: "02:00", end: "25:00", wantErr: true},
+		{start: "03:00", end: "03:00", wantErr: true},
+	}
+	for _, tt := range tests {
+		w, err := ParseWindow(tt.start, tt.end)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseWindow(%q, %q) = %v, want error", tt.start, tt.end, w)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseWindow(%q, %q) error = %v", tt.start, tt.end, err)
+			continue
+		}
+		if got := w.String(); got != tt.start+"-"+tt.end {
+			t.Errorf("String() = %q, want %q", got, tt.start+"-"+tt.end)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	tests := []struct {
+		start, end string
+		t          time.Time
+		want       bool
+		untilOpen  time.Duration
+	}{
+		{start: "02:00", end: "05:00", t: at(1, 30), untilOpen: 30 * time.Minute},
+		{start: "02:00", end: "05:00", t: at(2, 0), want: true},
+		{start: "02:00", end: "05:00", t: at(4, 59), want: true},
+		{start: "02:00", end: "05:00", t: at(5, 0), untilOpen: 21 * time.Hour},
+		{start: "22:00", end: "04:00", t: at(23, 0), want: true},
+		{start: "22:00", end: "04:00", t: at(3, 0), want: true},
+		{start: "22:00", end: "04:00", t: at(12, 0), untilOpen: 10 * time.Hour},
+	}
+	for _, tt := range tests {
+		w, err := ParseWindow(tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("ParseWindow() error = %v", err)
+		}
+		if got := w.Contains(tt.t); got != tt.want {
+			t.Errorf("%s Contains(%s) = %v, want %v", w, tt.t.Format("15:04"), got, tt.want)
+		}
+		if got := w.UntilOpen(tt.t); got != tt.untilOpen {
+			t.Errorf("%s UntilOpen(%s) = %v, want %v", w, tt.t.Format("15:04"), got, tt.untilOpen)
+		}
+	}
+}
+
+func TestWindowWait(t *testing.T) {
+	w, err := ParseWindow("02:00", "05:00")
+	if err != nil {
+		t.Fatalf("ParseWindow() error = %v", err)
+	}
+
+	w.now = func() time.Time { return at(3, 0) }
+	if err := w.Wait(context.Background()); err != nil {
+		t.Errorf("Wait() inside the window = %v", err)
+	}
+
+	w.now = func() time.Time { return at(12, 0) }
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := w.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() outside the window = %v, want deadline exceeded", err)
+	}
+}
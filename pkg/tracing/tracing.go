@@ -0,0 +1,270 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// ExportTimeout bounds how long exporting a finished trace may take
+const ExportTimeout = 10 * time.Second
+
+// Tracer records one trace per update attempt and exports it via OTLP/HTTP
+// using the JSON encoding, which avoids pulling the OpenTelemetry SDK into
+// the binary. A nil *Tracer is valid and records nothing.
+type Tracer struct {
+	endpoint string
+	resource []attribute
+	client   *http.Client
+
+	// exports tracks the traces being exported in the background
+	exports sync.WaitGroup
+}
+
+// Span is a single timed operation within a trace. All methods are no-ops on
+// a nil *Span, so callers never need to check whether tracing is enabled.
+type Span struct {
+	trace    *traceData
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    []attribute
+	err      error
+}
+
+type traceData struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	spans  []*Span
+}
+
+type attribute struct {
+	Key   string         `json:"key"`
+	Value attributeValue `json:"value"`
+}
+
+type attributeValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+// NewTracer creates a tracer exporting to the OTLP/HTTP endpoint (e.g.
+// http://collector:4318). It returns nil if endpoint is empty.
+func NewTracer(endpoint, serviceName, version, component string) *Tracer {
+	if endpoint == "" {
+		return nil
+	}
+
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+
+	return &Tracer{
+		endpoint: endpoint,
+		resource: []attribute{
+			newAttribute("service.name", serviceName),
+			newAttribute("service.version", version),
+			newAttribute("smut.component", component),
+		},
+		client: &http.Client{Timeout: ExportTimeout},
+	}
+}
+
+// StartTrace starts the root span of a new trace
+func (t *Tracer) StartTrace(name string, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+	span := &Span{
+		trace:   &traceData{tracer: t},
+		traceID: randomID(16),
+		spanID:  randomID(8),
+		name:    name,
+		start:   start,
+	}
+	span.trace.spans = append(span.trace.spans, span)
+	return span
+}
+
+// StartChild starts a child span now
+func (s *Span) StartChild(name string) *Span {
+	return s.StartChildAt(name, time.Now())
+}
+
+// StartChildAt starts a child span at the given time
+func (s *Span) StartChildAt(name string, start time.Time) *Span {
+	if s == nil {
+		return nil
+	}
+	child := &Span{
+		trace:    s.trace,
+		traceID:  s.traceID,
+		spanID:   randomID(8),
+		parentID: s.spanID,
+		name:     name,
+		start:    start,
+	}
+	s.trace.mu.Lock()
+	s.trace.spans = append(s.trace.spans, child)
+	s.trace.mu.Unlock()
+	return child
+}
+
+// SetAttribute records a string, integer or boolean attribute on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.trace.mu.Lock()
+	s.attrs = append(s.attrs, newAttribute(key, value))
+	s.trace.mu.Unlock()
+}
+
+// End finishes the span, marking it as failed if err is non-nil. Ending the
+// root span exports the whole trace in the background, see Flush.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.trace.mu.Lock()
+	s.end = time.Now()
+	s.err = err
+	s.trace.mu.Unlock()
+
+	if s.parentID == "" {
+		tracer := s.trace.tracer
+		tracer.exports.Add(1)
+		go func() {
+			defer tracer.exports.Done()
+			s.trace.export()
+		}()
+	}
+}
+
+// Flush waits for the traces being exported, at most until timeout, so the
+// trace of an update interrupted by a shutdown is not lost on exit
+func (t *Tracer) Flush(timeout time.Duration) {
+	if t == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		t.exports.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logging.Warnf("Trace export did not finish within %v, dropping it", timeout)
+	}
+}
+
+func newAttribute(key string, value interface{}) attribute {
+	attr := attribute{Key: key}
+	switch v := value.(type) {
+	case int:
+		i := strconv.FormatInt(int64(v), 10)
+		attr.Value.IntValue = &i
+	case int64:
+		i := strconv.FormatInt(v, 10)
+		attr.Value.IntValue = &i
+	case bool:
+		attr.Value.BoolValue = &v
+	default:
+		str := fmt.Sprint(v)
+		attr.Value.StringValue = &str
+	}
+	return attr
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// export sends all spans of the trace to the collector
+func (d *traceData) export() {
+	d.mu.Lock()
+	spans := make([]map[string]interface{}, 0, len(d.spans))
+	for _, s := range d.spans {
+		end := s.end
+		if end.IsZero() {
+			// Children that were never ended are closed at export time
+			end = time.Now()
+		}
+		status := map[string]interface{}{"code": 1} // STATUS_CODE_OK
+		if s.err != nil {
+			status = map[string]interface{}{"code": 2, "message": s.err.Error()} // STATUS_CODE_ERROR
+		}
+		span := map[string]interface{}{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(end.UnixNano(), 10),
+			"attributes":        append([]attribute{}, s.attrs...),
+			"status":            status,
+		}
+		if s.parentID != "" {
+			span["parentSpanId"] = s.parentID
+		}
+		spans = append(spans, span)
+	}
+	d.mu.Unlock()
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{"attributes": d.tracer.resource},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "smut"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logging.Warnf("Failed to encode trace: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.tracer.endpoint, bytes.NewReader(body))
+	if err != nil {
+		logging.Warnf("Failed to create trace export request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.tracer.client.Do(req)
+	if err != nil {
+		logging.Warnf("Failed to export trace: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		logging.Warnf("Trace export rejected with status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlushWaitsForExport(t *testing.T) {
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("export path = %s, want /v1/traces", r.URL.Path)
+		}
+		var payload map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decoding export: %v", err)
+		}
+		// Slow enough that exiting without Flush would lose the trace
+		time.Sleep(100 * time.Millisecond)
+		received.Add(1)
+	}))
+	defer server.Close()
+
+	tracer := NewTracer(server.URL, "smut", "test", "mdb")
+	span := tracer.StartTrace("update", time.Now())
+	span.StartChild("download").End(nil)
+	span.End(errors.New("interrupted"))
+
+	tracer.Flush(5 * time.Second)
+	if got := received.Load(); got != 1 {
+		t.Fatalf("exports received after Flush = %d, want 1", got)
+	}
+}
+
+func TestFlushTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	tracer := NewTracer(server.URL, "smut", "test", "mdb")
+	tracer.StartTrace("update", time.Now()).End(nil)
+
+	start := time.Now()
+	tracer.Flush(50 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Flush took %v, want it bounded by its timeout", elapsed)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.StartTrace("update", time.Now())
+	span.StartChild("download").End(nil)
+	span.End(nil)
+	tracer.Flush(time.Second)
+}
@@ -0,0 +1,257 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/librescoot/smut/pkg/logging"
+)
+
+// artifactExt is the extension of artifacts picked up from the watched directory
+const artifactExt = ".mender"
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// unsettled marks a pending artifact whose state has not been read since its
+// last change
+var unsettled = fileState{size: -1}
+
+// dirID identifies the directory behind a path, which changes when a
+// filesystem is mounted on top of it or unmounted
+type dirID struct {
+	dev uint64
+	ino uint64
+}
+
+// Watcher watches a directory (typically the mount point of a USB stick) for
+// newly appearing mender artifacts. Artifacts are discovered through fsnotify.
+// inotify does not report files that appear when a filesystem is mounted on
+// top of the watched directory, so every interval the watcher also checks
+// whether the directory was replaced by a mount or unmount, and then renews
+// the watch and rescans it.
+type Watcher struct {
+	dir      string
+	interval time.Duration
+	notify   *fsnotify.Watcher
+
+	// mount identifies the directory the watch was added to, valid while watching
+	mount    dirID
+	watching bool
+
+	// seen holds artifacts that were already handed out
+	seen map[string]fileState
+	// pending holds candidates waiting for their size to settle
+	pending map[string]fileState
+}
+
+// NewWatcher creates a watcher for dir. Artifacts already present at startup,
+// e.g. on a stick inserted before boot, are handed out by the first Wait once
+// their size has settled. A stick left plugged in after the install is
+// skipped by the update flow, since its artifact is the one running.
+func NewWatcher(dir string, interval time.Duration) (*Watcher, error) {
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("error creating directory watcher: %w", err)
+	}
+	w := &Watcher{
+		dir:      dir,
+		interval: interval,
+		notify:   notify,
+		seen:     make(map[string]fileState),
+		pending:  make(map[string]fileState),
+	}
+	w.rewatch()
+	return w, nil
+}
+
+// Close stops watching the directory
+func (w *Watcher) Close() error {
+	return w.notify.Close()
+}
+
+// Wait blocks until a new artifact has appeared and its size has settled,
+// returning it as a file:// URL.
+func (w *Watcher) Wait(ctx context.Context) (string, error) {
+	logging.Infof("Watching %s for new artifacts", w.dir)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case event, ok := <-w.notify.Events:
+			if !ok {
+				return "", errors.New("directory watcher closed")
+			}
+			w.handleEvent(event)
+			continue
+		case err, ok := <-w.notify.Errors:
+			if !ok {
+				return "", errors.New("directory watcher closed")
+			}
+			// Events were lost, rescan on the next tick
+			logging.Warnf("Error watching %s: %v", w.dir, err)
+			w.watching = false
+			continue
+		case <-ticker.C:
+		}
+
+		w.rewatch()
+		if url, ok := w.settled(); ok {
+			return url, nil
+		}
+	}
+}
+
+// Retry hands out an artifact returned by Wait again once it is still
+// unchanged after an interval, for an update that could not be handled yet
+func (w *Watcher) Retry(url string) {
+	path := strings.TrimPrefix(url, "file://")
+	if _, ok := w.seen[path]; !ok {
+		return
+	}
+	delete(w.seen, path)
+	w.pending[path] = unsettled
+}
+
+// handleEvent records an artifact that was created, written or removed
+func (w *Watcher) handleEvent(event fsnotify.Event) {
+	if !strings.HasSuffix(event.Name, artifactExt) {
+		return
+	}
+	path, err := filepath.Abs(event.Name)
+	if err != nil {
+		return
+	}
+	// Forget artifacts that were removed so re-adding them triggers again
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		delete(w.seen, path)
+		delete(w.pending, path)
+		return
+	}
+	if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) {
+		delete(w.seen, path)
+		w.pending[path] = unsettled
+	}
+}
+
+// rewatch renews the watch when the directory was replaced by a mount or
+// unmount, and rescans it since files that came with a mount produce no events
+func (w *Watcher) rewatch() {
+	id, err := identify(w.dir)
+	if err != nil {
+		// The mount point may disappear while the stick is unplugged
+		w.watching = false
+		return
+	}
+	if w.watching && id == w.mount {
+		return
+	}
+
+	// The old watch went with the replaced directory, errors don't matter
+	w.notify.Remove(w.dir)
+	if err := w.notify.Add(w.dir); err != nil {
+		logging.Warnf("Failed to watch %s: %v", w.dir, err)
+		return
+	}
+	w.watching = true
+	w.mount = id
+
+	artifacts, err := w.scan()
+	if err != nil {
+		logging.Warnf("Scan of %s failed: %v", w.dir, err)
+		return
+	}
+	for path := range w.seen {
+		if _, ok := artifacts[path]; !ok {
+			delete(w.seen, path)
+		}
+	}
+	for path := range w.pending {
+		if _, ok := artifacts[path]; !ok {
+			delete(w.pending, path)
+		}
+	}
+	for path, state := range artifacts {
+		if seen, ok := w.seen[path]; ok && seen == state {
+			continue
+		}
+		if _, ok := w.pending[path]; !ok {
+			logging.Infof("Found artifact: %s", path)
+			w.pending[path] = unsettled
+		}
+	}
+}
+
+// settled returns a pending artifact whose size and mtime are unchanged since
+// the last tick
+func (w *Watcher) settled() (string, bool) {
+	for path, state := range w.pending {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			delete(w.pending, path)
+			continue
+		}
+		current := fileState{size: info.Size(), modTime: info.ModTime()}
+		if current != state {
+			w.pending[path] = current
+			continue
+		}
+		delete(w.pending, path)
+		w.seen[path] = state
+		logging.Infof("New artifact found: %s", path)
+		return "file://" + path, true
+	}
+	return "", false
+}
+
+// scan lists the artifacts currently in the watched directory
+func (w *Watcher) scan() (map[string]fileState, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading watch directory: %w", err)
+	}
+
+	artifacts := make(map[string]fileState)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), artifactExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path, err := filepath.Abs(filepath.Join(w.dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		artifacts[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+	}
+	return artifacts, nil
+}
+
+// identify returns the device and inode of dir
+func identify(dir string) (dirID, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return dirID{}, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return dirID{}, fmt.Errorf("no device and inode for %s", dir)
+	}
+	return dirID{dev: uint64(st.Dev), ino: st.Ino}, nil
+}
@@ -0,0 +1,155 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testInterval = 10 * time.Millisecond
+
+func waitFor(t *testing.T, w *Watcher) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	url, err := w.Wait(ctx)
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	return url
+}
+
+func newTestWatcher(t *testing.T, dir string) *Watcher {
+	t.Helper()
+	w, err := NewWatcher(dir, testInterval)
+	if err != nil {
+		t.Fatalf("NewWatcher: %v", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+func writeArtifact(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("artifact"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcherPicksUpArtifactPresentAtStartup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "update.mender")
+	writeArtifact(t, path)
+
+	w := newTestWatcher(t, dir)
+	if got, want := waitFor(t, w), "file://"+path; got != want {
+		t.Fatalf("Wait = %s, want %s", got, want)
+	}
+}
+
+func TestWatcherIgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeArtifact(t, filepath.Join(dir, "update.mender.sha256"))
+	w := newTestWatcher(t, dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*testInterval)
+	defer cancel()
+	if url, err := w.Wait(ctx); err == nil {
+		t.Fatalf("Wait = %s, want no artifact", url)
+	}
+}
+
+func TestWatcherNewAndReaddedArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	w := newTestWatcher(t, dir)
+
+	path := filepath.Join(dir, "update.mender")
+	writeArtifact(t, path)
+	if got, want := waitFor(t, w), "file://"+path; got != want {
+		t.Fatalf("Wait = %s, want %s", got, want)
+	}
+
+	// The same artifact is not handed out twice
+	ctx, cancel := context.WithTimeout(context.Background(), 5*testInterval)
+	if url, err := w.Wait(ctx); err == nil {
+		t.Fatalf("Wait = %s, want no artifact", url)
+	}
+	cancel()
+
+	// Removing and re-adding it triggers again
+	os.Remove(path)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*testInterval)
+	w.Wait(ctx)
+	cancel()
+	writeArtifact(t, path)
+	if got, want := waitFor(t, w), "file://"+path; got != want {
+		t.Fatalf("Wait after re-adding = %s, want %s", got, want)
+	}
+}
+
+func TestWatcherPrunesPendingArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "partial.mender")
+	writeArtifact(t, path)
+	w := newTestWatcher(t, dir)
+	if _, ok := w.pending[path]; !ok {
+		t.Fatalf("artifact present at startup is not pending")
+	}
+
+	// Unplugged before its size settled
+	os.Remove(path)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*testInterval)
+	defer cancel()
+	w.Wait(ctx)
+	if len(w.pending) != 0 {
+		t.Fatalf("pending = %v, want removed artifacts pruned", w.pending)
+	}
+}
+
+func TestWatcherRescansReplacedDirectory(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "usb")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWatcher(t, dir)
+
+	// A mount replaces the directory without any event on the watched one
+	staged := filepath.Join(root, "stick")
+	if err := os.Mkdir(staged, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeArtifact(t, filepath.Join(staged, "update.mender"))
+	if err := os.Rename(dir, filepath.Join(root, "old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(staged, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := waitFor(t, w), "file://"+filepath.Join(dir, "update.mender"); got != want {
+		t.Fatalf("Wait = %s, want %s", got, want)
+	}
+
+	// Files added later are seen through the renewed watch
+	path := filepath.Join(dir, "next.mender")
+	writeArtifact(t, path)
+	if got, want := waitFor(t, w), "file://"+path; got != want {
+		t.Fatalf("Wait = %s, want %s", got, want)
+	}
+}
+
+func TestWatcherRetry(t *testing.T) {
+	dir := t.TempDir()
+	w := newTestWatcher(t, dir)
+	path := filepath.Join(dir, "update.mender")
+	writeArtifact(t, path)
+	url := waitFor(t, w)
+
+	w.Retry(url)
+	if got := waitFor(t, w); got != url {
+		t.Fatalf("Wait after Retry = %s, want %s", got, url)
+	}
+}